@@ -0,0 +1,268 @@
+package calc
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// maxRangeLength is the maximum number of values in the range of macros like sum,
+// it prevents expressions like sum{k, 1, 1e15, k} from running forever.
+const maxRangeLength = 1000000
+
+// variableMacros contains the built-in macros whose first parameter can declare a
+// variable, e.g. the k in sum{k, 1, 10, k^2}. The parser treats the variable like a
+// parameter of an anonymous function, so it shadows constants and units with the
+// same name.
+var variableMacros = map[string]bool{
+	"sum":   true,
+	"prod":  true,
+	"minof": true,
+	"maxof": true,
+}
+
+// aggregate is a macro that evaluates an expression for every value of a range and
+// combines the results. It implements sum, prod, minof and maxof, which can be
+// invoked with a variable and an expression, e.g. sum{k, 1, 10, k^2}, or with an
+// anonymous function, e.g. sum{k -> k^2, 1, 10}. Invoked with a single list, e.g.
+// sum{[1, 2, 3]}, the elements of the list are combined.
+type aggregate struct {
+	name string
+	// values is the list whose elements are combined if the macro has been
+	// invoked with a single parameter
+	values types.Node
+	// variable is bound to the values of the range while body is evaluated, it
+	// is empty if f is used instead
+	variable string
+	body     types.Node
+	// f is called with the values of the range if no variable is used
+	f types.Lambda
+	// from and to are the bounds of the range, see forRange
+	from, to types.Node
+	// combine combines the result acc of the previous values with the result v
+	// of the current one, acc is nil for the first value
+	combine func(env *types.Env, acc, v types.Value) (types.Value, error)
+	// empty is the result for an empty range, if it is nil an empty range is an
+	// error
+	empty types.Value
+}
+
+// newAggregate returns the types.NewMacro for an aggregate, see aggregate for the
+// fields.
+func newAggregate(name string, empty types.Value, combine func(env *types.Env, acc, v types.Value) (types.Value, error)) types.NewMacro {
+	return func(parameters []types.Node) (types.Macro, error) {
+		a := &aggregate{name: name, combine: combine, empty: empty}
+		switch len(parameters) {
+		case 1:
+			a.values = parameters[0]
+		case 3:
+			f, err := lambdaParameter(name, parameters, 0, 1)
+			if err != nil {
+				return nil, err
+			}
+			a.f, a.from, a.to = f, parameters[1], parameters[2]
+		case 4:
+			v, ok := parameters[0].(types.Variable)
+			if !ok {
+				return nil, fmt.Errorf("%s: argument 1 has to be the name of a variable", name)
+			}
+			a.variable, a.from, a.to, a.body = v.Name(), parameters[1], parameters[2], parameters[3]
+		default:
+			return nil, fmt.Errorf("%s: expected 1, 3 or 4 argument(s) but got %d argument(s)", name, len(parameters))
+		}
+		return a, nil
+	}
+}
+
+func (a *aggregate) Eval(env *types.Env) (float64, error) {
+	return evalFloat(a, env)
+}
+
+// EvalValue evaluates the bounds of the range and then the expression for every
+// value of the range.
+func (a *aggregate) EvalValue(env *types.Env) (types.Value, error) {
+	if a.values != nil {
+		return a.evalList(env)
+	}
+	from, to, err := evalRange(env, a.from, a.to)
+	if err != nil {
+		return nil, err
+	}
+	var acc types.Value
+	err = forRange(env, a.name, from, to, func(k types.Value) error {
+		var v types.Value
+		var err error
+		if a.f != nil {
+			v, err = a.f.Call(env, []types.Value{k})
+		} else {
+			v, err = types.EvalWith(env, a.body, a.variable, k)
+		}
+		if err != nil {
+			return err
+		}
+		acc, err = a.combine(env, acc, v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acc != nil {
+		return acc, nil
+	}
+	if a.empty == nil {
+		return nil, fmt.Errorf("%s: the range from %v to %v is empty", a.name, from, to)
+	}
+	return convertValue(env, a.empty)
+}
+
+// evalList combines the elements of the list values.
+func (a *aggregate) evalList(env *types.Env) (types.Value, error) {
+	l, err := evalList(env, a.name, a.values)
+	if err != nil {
+		return nil, err
+	}
+	var acc types.Value
+	for _, v := range l {
+		if acc, err = a.combine(env, acc, v); err != nil {
+			return nil, err
+		}
+	}
+	if acc != nil {
+		return acc, nil
+	}
+	if a.empty == nil {
+		return nil, fmt.Errorf("%s: the list is empty", a.name)
+	}
+	return convertValue(env, a.empty)
+}
+
+// accumulate combines the values of an aggregate using operator.
+func accumulate(operator rune) func(env *types.Env, acc, v types.Value) (types.Value, error) {
+	return func(env *types.Env, acc, v types.Value) (types.Value, error) {
+		if acc == nil {
+			return v, nil
+		}
+		return calcValue(env, operator, acc, v)
+	}
+}
+
+// extreme selects the value of an aggregate for which `value operator others`
+// holds, i.e. the minimum for '<' and the maximum for '>'.
+func extreme(operator rune) func(env *types.Env, acc, v types.Value) (types.Value, error) {
+	return func(env *types.Env, acc, v types.Value) (types.Value, error) {
+		if acc == nil {
+			return v, nil
+		}
+		ok, err := compare(env, operator, v, acc)
+		if err != nil || !ok {
+			return acc, err
+		}
+		return v, nil
+	}
+}
+
+// fold is a macro that combines the values of a range using an anonymous function,
+// e.g. fold{(acc, k) -> acc*k, 1, 1, 5} is 5!. The function is called with the
+// result of the previous call, or initial for the first value, and the value.
+// Instead of a range, fold also accepts a list, e.g. fold{(acc, x) -> acc + x, 0, l}.
+type fold struct {
+	f        types.Lambda
+	initial  types.Node
+	from, to types.Node
+	// values is the list that is used instead of the range, if any
+	values types.Node
+}
+
+// newFold implements the macro fold{f, initial, from, to} and fold{f, initial, list}.
+func newFold(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) < 3 || len(parameters) > 4 {
+		return nil, fmt.Errorf("fold: expected %s but got %d argument(s)", expectedArgs(3, 4), len(parameters))
+	}
+	f, err := lambdaParameter("fold", parameters, 0, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(parameters) == 3 {
+		return &fold{f: f, initial: parameters[1], values: parameters[2]}, nil
+	}
+	return &fold{f: f, initial: parameters[1], from: parameters[2], to: parameters[3]}, nil
+}
+
+func (f *fold) Eval(env *types.Env) (float64, error) {
+	return evalFloat(f, env)
+}
+
+// EvalValue evaluates initial and the bounds of the range and then calls the
+// function for every value of the range.
+func (f *fold) EvalValue(env *types.Env) (types.Value, error) {
+	acc, err := f.initial.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	if f.values != nil {
+		l, err := evalList(env, "fold", f.values)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range l {
+			if acc, err = f.f.Call(env, []types.Value{acc, v}); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	}
+	from, to, err := evalRange(env, f.from, f.to)
+	if err != nil {
+		return nil, err
+	}
+	err = forRange(env, "fold", from, to, func(k types.Value) error {
+		acc, err = f.f.Call(env, []types.Value{acc, k})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// evalRange evaluates the bounds of a range.
+func evalRange(env *types.Env, from, to types.Node) (types.Value, types.Value, error) {
+	start, err := from.EvalValue(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	end, err := to.EvalValue(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	return start, end, nil
+}
+
+// forRange calls f with from, from+1, from+2 and so on as long as the value is
+// less than or equal to to, using the number type of the Mode of env. The range is
+// empty if from is greater than to. name is the macro the range belongs to, it is
+// used in errors.
+func forRange(env *types.Env, name string, from, to types.Value, f func(k types.Value) error) error {
+	one := fromInt(env, big.NewInt(1))
+	k := from
+	for n := 0; ; n++ {
+		ok, err := compare(env, '≤', k, to)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if n == maxRangeLength {
+			return fmt.Errorf("%s: the range from %v to %v contains more than %d values", name, from, to, maxRangeLength)
+		}
+		if err := f(k); err != nil {
+			return err
+		}
+		k, err = calcValue(env, '+', k, one)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package calc
+
+import (
+	"github.com/maxmoehl/calc/types"
+)
+
+// assignment evaluates an expression and binds the result to a variable. The
+// result of the expression is also the result of the assignment.
+type assignment struct {
+	// name is the variable the result gets assigned to
+	name string
+	// value is the expression that is assigned to the variable
+	value types.Node
+	span
+}
+
+func (a *assignment) Eval(env *types.Env) (float64, error) {
+	return evalFloat(a, env)
+}
+
+func (a *assignment) EvalValue(env *types.Env) (types.Value, error) {
+	v, err := a.value.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	env.SetValue(a.name, v)
+	return v, nil
+}
@@ -0,0 +1,618 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// builtins contains all macros that are compiled into calc and available in every
+// Registry created by NewRegistry.
+var builtins = map[string]types.NewMacro{
+	// power and roots
+	"sqrt": withUnits(with(unary("sqrt", math.Sqrt), implementations{big: bigSqrt, rat: ratSqrt, complex: complexUnary(cmplx.Sqrt)}), nthRoot(2)),
+	"pow":  withUnits(with(binary("pow", math.Pow), implementations{rat: ratPow, complex: complexBinary(powComplex)}), powerOf),
+	"exp":  with(unary("exp", math.Exp), implementations{complex: complexUnary(cmplx.Exp)}),
+	// logarithms
+	"ln":   with(unary("ln", math.Log), implementations{complex: complexUnary(cmplx.Log)}),
+	"log":  with(newFunction("log", 1, 2, log), implementations{complex: complexLog}),
+	"log2": unary("log2", math.Log2),
+	// trigonometric functions
+	"sin":   unary("sin", math.Sin),
+	"cos":   unary("cos", math.Cos),
+	"tan":   unary("tan", math.Tan),
+	"asin":  unary("asin", math.Asin),
+	"acos":  unary("acos", math.Acos),
+	"atan":  unary("atan", math.Atan),
+	"atan2": binary("atan2", math.Atan2),
+	// hyperbolic functions
+	"sinh":  unary("sinh", math.Sinh),
+	"cosh":  unary("cosh", math.Cosh),
+	"tanh":  unary("tanh", math.Tanh),
+	"asinh": unary("asinh", math.Asinh),
+	"acosh": unary("acosh", math.Acosh),
+	"atanh": unary("atanh", math.Atanh),
+	// rounding
+	"abs":   withUnits(with(unary("abs", math.Abs), implementations{big: bigAbs, rat: ratAbs, complex: complexAbs}), sameUnit),
+	"floor": withUnits(with(unary("floor", math.Floor), implementations{big: bigFloor, rat: ratFloor}), sameUnit),
+	"ceil":  withUnits(with(unary("ceil", math.Ceil), implementations{big: bigCeil, rat: ratCeil}), sameUnit),
+	"round": withUnits(with(unary("round", math.Round), implementations{big: bigRound, rat: ratRound}), sameUnit),
+	"trunc": withUnits(with(unary("trunc", math.Trunc), implementations{big: bigTrunc, rat: ratTrunc}), sameUnit),
+	// complex numbers
+	"re":   withUnits(with(unary("re", realPart), implementations{rat: ratReal, complex: complexReal}), sameUnit),
+	"im":   withUnits(with(unary("im", imaginaryPart), implementations{rat: ratImag, complex: complexImag}), sameUnit),
+	"arg":  with(unary("arg", argument), implementations{complex: complexArg}),
+	"conj": withUnits(with(unary("conj", realPart), implementations{rat: ratReal, complex: complexUnary(cmplx.Conj)}), sameUnit),
+	// miscellaneous
+	"min":   withUnits(flat(with(newFunction("min", 1, -1, minimum), implementations{big: bigMinimum, rat: ratMinimum})), sameUnit),
+	"max":   withUnits(flat(with(newFunction("max", 1, -1, maximum), implementations{big: bigMaximum, rat: ratMaximum})), sameUnit),
+	"hypot": withUnits(binary("hypot", math.Hypot), sameUnit),
+	"mod":   withUnits(with(binary("mod", math.Mod), implementations{rat: ratMod}), sameUnit),
+	// conditions
+	"if": newConditional,
+	// ranges
+	"sum":   newAggregate("sum", types.Float(0), accumulate('+')),
+	"prod":  newAggregate("prod", types.Float(1), accumulate('*')),
+	"minof": newAggregate("minof", nil, extreme('<')),
+	"maxof": newAggregate("maxof", nil, extreme('>')),
+	"fold":  newFold,
+	// lists
+	"len":   newListFunction("len", 1, 1, length),
+	"mean":  newListFunction("mean", 1, -1, mean),
+	"dot":   newListFunction("dot", 2, 2, dot),
+	"range": newListFunction("range", 2, 2, rangeList),
+	"map":   newMapping,
+	// matrices
+	"matmul":    newListFunction("matmul", 2, 2, matrixProduct),
+	"transpose": newListFunction("transpose", 1, 1, transpose),
+	"det":       newListFunction("det", 1, 1, determinant),
+	"inv":       newListFunction("inv", 1, 1, inverse),
+	"solve":     newListFunction("solve", 2, 2, solve),
+	"identity":  newListFunction("identity", 1, 1, identityMatrix),
+}
+
+// function is a macro that evaluates all of its parameters and passes the results
+// to f. It is used to implement all built-in macros except for if, which only
+// evaluates the parameter it returns, see conditional, and the macros that evaluate
+// a parameter for a range of values, see aggregate and fold. In modes other than
+// types.ModeFloat the implementation for the mode is used, if there is one. The
+// unit of the result is determined by units, see unitRule.
+type function struct {
+	name string
+	f    func(args []float64) float64
+	implementations
+	// flatten replaces lists in the arguments by their elements instead of
+	// applying the function to each element, see flat
+	flatten bool
+	// units determines how quantities in the arguments are handled, if it is nil
+	// only dimensionless arguments are accepted, see noUnits
+	units      unitRule
+	parameters []types.Node
+}
+
+// implementations contains the implementations of a function for modes other than
+// types.ModeFloat. Each of them is optional.
+type implementations struct {
+	// big is used in types.ModeBigFloat
+	big bigFunction
+	// rat is used in types.ModeRational
+	rat ratFunction
+	// complex is used in types.ModeComplex
+	complex complexFunction
+}
+
+// bigFunction is the implementation of a function for types.ModeBigFloat. The
+// result has to be calculated with the precision prec. If args are outside of the
+// domain of the function nil is returned.
+type bigFunction func(args []*big.Float, prec uint) *big.Float
+
+// ratFunction is the implementation of a function for types.ModeRational. The result
+// has to be exact. If it is not rational or args are outside of the domain of the
+// function nil is returned, the function is then evaluated using f to tell both
+// cases apart.
+type ratFunction func(args []*big.Rat) *big.Rat
+
+// complexFunction is the implementation of a function for types.ModeComplex.
+type complexFunction func(args []complex128) complex128
+
+func (fn *function) Eval(env *types.Env) (float64, error) {
+	return evalFloat(fn, env)
+}
+
+// EvalValue evaluates the parameters and uses the implementation for the Mode of
+// env to evaluate the function. If there is none, the function is evaluated using
+// f. If arguments are lists, the function is applied to each element, unless
+// flatten is set.
+func (fn *function) EvalValue(env *types.Env) (types.Value, error) {
+	args := make([]types.Value, len(fn.parameters))
+	for i, p := range fn.parameters {
+		v, err := p.EvalValue(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if fn.flatten {
+		args = flatten(args)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s: the arguments do not contain any values", fn.name)
+		}
+		return fn.apply(env, args)
+	}
+	return broadcast(args, func(args []types.Value) (types.Value, error) {
+		return fn.apply(env, args)
+	})
+}
+
+// apply evaluates the function for args, which must not contain lists.
+func (fn *function) apply(env *types.Env, args []types.Value) (types.Value, error) {
+	for _, a := range args {
+		if _, ok := a.(types.Quantity); ok {
+			return fn.applyUnits(env, args)
+		}
+	}
+	switch {
+	case env.Mode() == types.ModeBigFloat && fn.big != nil:
+		return fn.evalBig(args, env.Precision())
+	case env.Mode() == types.ModeRational && fn.rat != nil:
+		res, err := fn.evalRat(args)
+		if res != nil || err != nil {
+			return res, err
+		}
+	case env.Mode() == types.ModeComplex:
+		return fn.evalComplex(args)
+	}
+	reals := make([]float64, len(args))
+	for i, a := range args {
+		reals[i] = a.Float64()
+	}
+	res := fn.f(reals)
+	if math.IsNaN(res) && !containsNaN(reals) {
+		return nil, fmt.Errorf("%s: arguments %v are %w", fn.name, reals, types.ErrDomain)
+	}
+	if env.Mode() == types.ModeRational && !math.IsInf(res, 0) && !(exactIntegers(reals) && exactIntegers([]float64{res})) {
+		return nil, fmt.Errorf("%s: the result for the arguments %v is %w", fn.name, args, ErrNotRational)
+	}
+	return convertValue(env, types.Float(res))
+}
+
+// exactIntegers checks if all values are integers that can be represented exactly
+// by float64. Functions that are calculated using float64 in types.ModeRational are
+// only exact if their arguments and result are such integers, e.g. log{100}.
+func exactIntegers(values []float64) bool {
+	for _, f := range values {
+		if f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+			return false
+		}
+	}
+	return true
+}
+
+// applyUnits evaluates the function for args, which contain quantities. The
+// numbers of the quantities are passed to the function and the result gets the
+// unit determined by the unitRule of the function.
+func (fn *function) applyUnits(env *types.Env, args []types.Value) (types.Value, error) {
+	rule := fn.units
+	if rule == nil {
+		rule = noUnits
+	}
+	values, unit, err := rule(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn.name, err)
+	}
+	res, err := fn.apply(env, values)
+	if err != nil {
+		return nil, err
+	}
+	return newQuantity(env, res, unit)
+}
+
+// evalBig evaluates the function using big.
+func (fn *function) evalBig(values []types.Value, prec uint) (types.Value, error) {
+	args := make([]*big.Float, len(values))
+	for i, v := range values {
+		var err error
+		args[i], err = toBig(v, prec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res := fn.big(args, prec)
+	if res == nil {
+		return nil, fmt.Errorf("%s: arguments %v are %w", fn.name, args, types.ErrDomain)
+	}
+	return types.NewBigFloat(res), nil
+}
+
+// evalRat evaluates the function using rat. If rat returns nil, nil is returned as
+// well.
+func (fn *function) evalRat(values []types.Value) (types.Value, error) {
+	args := make([]*big.Rat, len(values))
+	for i, v := range values {
+		var err error
+		args[i], err = toRat(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res := fn.rat(args)
+	if res == nil {
+		return nil, nil
+	}
+	return types.NewRat(res), nil
+}
+
+// evalComplex evaluates the function using complex. Functions without complex
+// implementation are evaluated using f if all arguments are real numbers.
+func (fn *function) evalComplex(values []types.Value) (types.Value, error) {
+	args := make([]complex128, len(values))
+	for i, v := range values {
+		args[i] = toComplex(v)
+	}
+	if fn.complex != nil {
+		return types.Complex(fn.complex(args)), nil
+	}
+	reals := make([]float64, len(args))
+	for i, c := range args {
+		if imag(c) != 0 {
+			return nil, fmt.Errorf("%s: complex arguments %v are %w", fn.name, args, types.ErrDomain)
+		}
+		reals[i] = real(c)
+	}
+	res := fn.f(reals)
+	if math.IsNaN(res) && !containsNaN(reals) {
+		return nil, fmt.Errorf("%s: arguments %v are %w", fn.name, reals, types.ErrDomain)
+	}
+	return types.Complex(complex(res, 0)), nil
+}
+
+// newFunction creates a types.NewMacro for a function that accepts at least minArgs
+// and at most maxArgs parameters. If maxArgs is negative the number of parameters
+// is not limited.
+func newFunction(name string, minArgs, maxArgs int, f func(args []float64) float64) types.NewMacro {
+	return func(parameters []types.Node) (types.Macro, error) {
+		if len(parameters) < minArgs || (maxArgs >= 0 && len(parameters) > maxArgs) {
+			return nil, fmt.Errorf("%s: expected %s but got %d argument(s)",
+				name, expectedArgs(minArgs, maxArgs), len(parameters))
+		}
+		return &function{
+			name:       name,
+			f:          f,
+			parameters: parameters,
+		}, nil
+	}
+}
+
+// unary creates a types.NewMacro for a function with exactly one parameter.
+func unary(name string, f func(float64) float64) types.NewMacro {
+	return newFunction(name, 1, 1, func(args []float64) float64 {
+		return f(args[0])
+	})
+}
+
+// binary creates a types.NewMacro for a function with exactly two parameters.
+func binary(name string, f func(float64, float64) float64) types.NewMacro {
+	return newFunction(name, 2, 2, func(args []float64) float64 {
+		return f(args[0], args[1])
+	})
+}
+
+// with adds the implementations for other modes than types.ModeFloat to the
+// functions created by newFunction.
+func with(newFunction types.NewMacro, impl implementations) types.NewMacro {
+	return func(parameters []types.Node) (types.Macro, error) {
+		m, err := newFunction(parameters)
+		if err != nil {
+			return nil, err
+		}
+		m.(*function).implementations = impl
+		return m, nil
+	}
+}
+
+// flat makes the functions created by newFunction accept lists, whose elements are
+// passed to the function as separate arguments, e.g. max{[1, 3], 2} is max{1, 3, 2}.
+func flat(newFunction types.NewMacro) types.NewMacro {
+	return func(parameters []types.Node) (types.Macro, error) {
+		m, err := newFunction(parameters)
+		if err != nil {
+			return nil, err
+		}
+		m.(*function).flatten = true
+		return m, nil
+	}
+}
+
+// expectedArgs describes the number of arguments a function accepts.
+func expectedArgs(minArgs, maxArgs int) string {
+	switch {
+	case maxArgs < 0:
+		return fmt.Sprintf("at least %d argument(s)", minArgs)
+	case minArgs == maxArgs:
+		return fmt.Sprintf("%d argument(s)", minArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", minArgs, maxArgs)
+	}
+}
+
+// containsNaN checks if any of the values is NaN.
+func containsNaN(values []float64) bool {
+	for _, f := range values {
+		if math.IsNaN(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// log calculates the logarithm of args[0]. The base is 10 unless it is given
+// as second argument.
+func log(args []float64) float64 {
+	if len(args) == 2 {
+		return math.Log(args[0]) / math.Log(args[1])
+	}
+	return math.Log10(args[0])
+}
+
+// minimum returns the smallest of all args.
+func minimum(args []float64) float64 {
+	res := args[0]
+	for _, f := range args[1:] {
+		res = math.Min(res, f)
+	}
+	return res
+}
+
+// maximum returns the largest of all args.
+func maximum(args []float64) float64 {
+	res := args[0]
+	for _, f := range args[1:] {
+		res = math.Max(res, f)
+	}
+	return res
+}
+
+// bigSqrt calculates the square root of args[0].
+func bigSqrt(args []*big.Float, prec uint) *big.Float {
+	if args[0].Sign() < 0 {
+		return nil
+	}
+	return new(big.Float).SetPrec(prec).Sqrt(args[0])
+}
+
+// bigAbs returns the absolute value of args[0].
+func bigAbs(args []*big.Float, prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec).Abs(args[0])
+}
+
+// bigTrunc returns the integer part of args[0].
+func bigTrunc(args []*big.Float, prec uint) *big.Float {
+	if args[0].IsInf() {
+		return new(big.Float).SetPrec(prec).Set(args[0])
+	}
+	i, _ := args[0].Int(nil)
+	return new(big.Float).SetPrec(prec).SetInt(i)
+}
+
+// bigFloor returns the greatest integer value less than or equal to args[0].
+func bigFloor(args []*big.Float, prec uint) *big.Float {
+	t := bigTrunc(args, prec)
+	if args[0].Sign() < 0 && t.Cmp(args[0]) != 0 {
+		t.Sub(t, big.NewFloat(1))
+	}
+	return t
+}
+
+// bigCeil returns the least integer value greater than or equal to args[0].
+func bigCeil(args []*big.Float, prec uint) *big.Float {
+	t := bigTrunc(args, prec)
+	if args[0].Sign() > 0 && t.Cmp(args[0]) != 0 {
+		t.Add(t, big.NewFloat(1))
+	}
+	return t
+}
+
+// bigRound returns the nearest integer to args[0], rounding half away from zero
+// like math.Round.
+func bigRound(args []*big.Float, prec uint) *big.Float {
+	t := bigTrunc(args, prec)
+	if args[0].IsInf() {
+		return t
+	}
+	// the fractional part can be represented exactly with the precision of args[0]
+	frac := new(big.Float).SetPrec(args[0].Prec()).Sub(args[0], t)
+	half := big.NewFloat(0.5)
+	if frac.Cmp(half) >= 0 {
+		t.Add(t, big.NewFloat(1))
+	} else if frac.Neg(frac).Cmp(half) >= 0 {
+		t.Sub(t, big.NewFloat(1))
+	}
+	return t
+}
+
+// bigMinimum returns the smallest of all args.
+func bigMinimum(args []*big.Float, prec uint) *big.Float {
+	res := args[0]
+	for _, f := range args[1:] {
+		if f.Cmp(res) < 0 {
+			res = f
+		}
+	}
+	return new(big.Float).SetPrec(prec).Set(res)
+}
+
+// bigMaximum returns the largest of all args.
+func bigMaximum(args []*big.Float, prec uint) *big.Float {
+	res := args[0]
+	for _, f := range args[1:] {
+		if f.Cmp(res) > 0 {
+			res = f
+		}
+	}
+	return new(big.Float).SetPrec(prec).Set(res)
+}
+
+// ratAbs returns the absolute value of args[0].
+func ratAbs(args []*big.Rat) *big.Rat {
+	return new(big.Rat).Abs(args[0])
+}
+
+// ratTrunc returns the integer part of args[0].
+func ratTrunc(args []*big.Rat) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Quo(args[0].Num(), args[0].Denom()))
+}
+
+// ratFloor returns the greatest integer value less than or equal to args[0].
+func ratFloor(args []*big.Rat) *big.Rat {
+	t := ratTrunc(args)
+	if args[0].Sign() < 0 && !args[0].IsInt() {
+		t.Sub(t, big.NewRat(1, 1))
+	}
+	return t
+}
+
+// ratCeil returns the least integer value greater than or equal to args[0].
+func ratCeil(args []*big.Rat) *big.Rat {
+	t := ratTrunc(args)
+	if args[0].Sign() > 0 && !args[0].IsInt() {
+		t.Add(t, big.NewRat(1, 1))
+	}
+	return t
+}
+
+// ratRound returns the nearest integer to args[0], rounding half away from zero
+// like math.Round.
+func ratRound(args []*big.Rat) *big.Rat {
+	t := ratTrunc(args)
+	frac := new(big.Rat).Sub(args[0], t)
+	half := big.NewRat(1, 2)
+	if frac.Cmp(half) >= 0 {
+		t.Add(t, big.NewRat(1, 1))
+	} else if frac.Neg(frac).Cmp(half) >= 0 {
+		t.Sub(t, big.NewRat(1, 1))
+	}
+	return t
+}
+
+// ratSqrt returns the square root of args[0] if it is rational, e.g. 3/2 for 9/4.
+func ratSqrt(args []*big.Rat) *big.Rat {
+	if args[0].Sign() < 0 {
+		return nil
+	}
+	return ratRoot(args[0], 2)
+}
+
+// ratPow returns args[0]^args[1] if it is rational, see powRat.
+func ratPow(args []*big.Rat) *big.Rat {
+	res, err := powRat(args[0], args[1])
+	if err != nil {
+		return nil
+	}
+	return res.(types.Rat).Big()
+}
+
+// ratMod returns the remainder of args[0]/args[1] like math.Mod, i.e. the result
+// has the sign of args[0].
+func ratMod(args []*big.Rat) *big.Rat {
+	if args[1].Sign() == 0 {
+		return nil
+	}
+	q := new(big.Rat).Quo(args[0], args[1])
+	q = ratTrunc([]*big.Rat{q})
+	return q.Sub(args[0], q.Mul(q, args[1]))
+}
+
+// ratReal returns the real part of args[0], which is args[0] itself.
+func ratReal(args []*big.Rat) *big.Rat {
+	return new(big.Rat).Set(args[0])
+}
+
+// ratImag returns the imaginary part of args[0], which is always 0.
+func ratImag(args []*big.Rat) *big.Rat {
+	return new(big.Rat)
+}
+
+// ratMinimum returns the smallest of all args.
+func ratMinimum(args []*big.Rat) *big.Rat {
+	res := args[0]
+	for _, r := range args[1:] {
+		if r.Cmp(res) < 0 {
+			res = r
+		}
+	}
+	return new(big.Rat).Set(res)
+}
+
+// ratMaximum returns the largest of all args.
+func ratMaximum(args []*big.Rat) *big.Rat {
+	res := args[0]
+	for _, r := range args[1:] {
+		if r.Cmp(res) > 0 {
+			res = r
+		}
+	}
+	return new(big.Rat).Set(res)
+}
+
+// realPart returns the real part of x, which is x itself.
+func realPart(x float64) float64 {
+	return x
+}
+
+// imaginaryPart returns the imaginary part of x, which is always 0.
+func imaginaryPart(x float64) float64 {
+	return 0
+}
+
+// argument returns the angle of x in the complex plane, which is π for negative
+// numbers and 0 otherwise.
+func argument(x float64) float64 {
+	return math.Atan2(0, x)
+}
+
+// complexUnary creates a complexFunction for a function with exactly one parameter.
+func complexUnary(f func(complex128) complex128) complexFunction {
+	return func(args []complex128) complex128 {
+		return f(args[0])
+	}
+}
+
+// complexBinary creates a complexFunction for a function with exactly two
+// parameters.
+func complexBinary(f func(complex128, complex128) complex128) complexFunction {
+	return func(args []complex128) complex128 {
+		return f(args[0], args[1])
+	}
+}
+
+// complexLog calculates the logarithm of args[0]. The base is 10 unless it is given
+// as second argument.
+func complexLog(args []complex128) complex128 {
+	if len(args) == 2 {
+		return cmplx.Log(args[0]) / cmplx.Log(args[1])
+	}
+	return cmplx.Log10(args[0])
+}
+
+// complexAbs returns the absolute value of args[0].
+func complexAbs(args []complex128) complex128 {
+	return complex(cmplx.Abs(args[0]), 0)
+}
+
+// complexReal returns the real part of args[0].
+func complexReal(args []complex128) complex128 {
+	return complex(real(args[0]), 0)
+}
+
+// complexImag returns the imaginary part of args[0].
+func complexImag(args []complex128) complex128 {
+	return complex(imag(args[0]), 0)
+}
+
+// complexArg returns the angle of args[0] in the complex plane.
+func complexArg(args []complex128) complex128 {
+	return complex(cmplx.Phase(args[0]), 0)
+}
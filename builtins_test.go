@@ -0,0 +1,127 @@
+package calc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    float64
+		wantErr bool
+	}{
+		{name: "sqrt", arg: "sqrt{16}", want: 4},
+		{name: "pow", arg: "pow{2, 10}", want: 1024},
+		{name: "exp", arg: "exp{0}", want: 1},
+		{name: "ln", arg: "ln{exp{2}}", want: 2},
+		{name: "log base 10", arg: "log{1000}", want: 3},
+		{name: "log with base", arg: "log{8, 2}", want: 3},
+		{name: "log2", arg: "log2{1024}", want: 10},
+		{name: "sin", arg: "sin{0}", want: 0},
+		{name: "cos", arg: "cos{0}", want: 1},
+		{name: "tan", arg: "tan{0}", want: 0},
+		{name: "asin", arg: "asin{1}", want: math.Pi / 2},
+		{name: "acos", arg: "acos{1}", want: 0},
+		{name: "atan", arg: "atan{0}", want: 0},
+		{name: "atan2", arg: "atan2{1, 0}", want: math.Pi / 2},
+		{name: "sinh", arg: "sinh{0}", want: 0},
+		{name: "cosh", arg: "cosh{0}", want: 1},
+		{name: "tanh", arg: "tanh{0}", want: 0},
+		{name: "asinh", arg: "asinh{0}", want: 0},
+		{name: "acosh", arg: "acosh{1}", want: 0},
+		{name: "atanh", arg: "atanh{0}", want: 0},
+		{name: "abs", arg: "abs{-3}", want: 3},
+		{name: "floor", arg: "floor{2.7}", want: 2},
+		{name: "ceil", arg: "ceil{2.2}", want: 3},
+		{name: "round", arg: "round{2.5}", want: 3},
+		{name: "trunc", arg: "trunc{-2.7}", want: -2},
+		{name: "min", arg: "min{3, 1, 2}", want: 1},
+		{name: "max", arg: "max{3, 1, 2}", want: 3},
+		{name: "max single argument", arg: "max{3}", want: 3},
+		{name: "hypot", arg: "hypot{3, 4}", want: 5},
+		{name: "mod", arg: "mod{7, 3}", want: 1},
+		{name: "nested", arg: "max{abs{-4}, sqrt{9}} + 1", want: 5},
+		{name: "sum", arg: "sum{k, 1, 100, k^2}", want: 338350},
+		{name: "sum with anonymous function", arg: "sum{k -> k^2, 1, 100}", want: 338350},
+		{name: "sum of empty range", arg: "sum{k, 1, 0, k}", want: 0},
+		{name: "nested sum", arg: "sum{i, 1, 3, sum{j, 1, i, i*j}}", want: 25},
+		{name: "prod", arg: "prod{k, 1, 5, k}", want: 120},
+		{name: "prod of empty range", arg: "prod{k -> k, 1, 0}", want: 1},
+		{name: "minof", arg: "minof{x, -3, 3, x^2 - 2*x}", want: -1},
+		{name: "maxof", arg: "maxof{x -> 10 - abs{x - 2}, -5, 5}", want: 10},
+		{name: "fold", arg: "fold{(acc, k) -> acc*k, 1, 1, 5}", want: 120},
+		{name: "fold of empty range", arg: "fold{(acc, k) -> acc + k, 7, 1, 0}", want: 7},
+		{name: "anonymous function with conditional", arg: "sum{k -> k % 2 == 0 ? k : 0, 1, 10}", want: 30},
+		{name: "anonymous function shadows constant", arg: "sum{e -> e, 1, 3}", want: 6},
+		{name: "variable shadows constant", arg: "sum{e, 1, 3, e} + prod{pi, 1, 3, pi}", want: 12},
+		{name: "variable shadows unit", arg: "sum{m, 1, 3, 2*m}", want: 12},
+		{name: "len", arg: "len{[1, [2, 3], 4]}", want: 3},
+		{name: "mean", arg: "mean{[4, 8, 15]}", want: 9},
+		{name: "mean of lists and numbers", arg: "mean{[1, 2], 3, [[4]]}", want: 2.5},
+		{name: "dot", arg: "dot{[1, 2, 3], [4, 5, 6]}", want: 32},
+		{name: "min of list", arg: "min{[3, 1], 2}", want: 1},
+		{name: "max of list", arg: "max{[3, 7, 2]}", want: 7},
+		{name: "sum of list", arg: "sum{[1, 2, 3] * 2}", want: 12},
+		{name: "prod of range", arg: "prod{range{1, 5}}", want: 120},
+		{name: "minof list", arg: "minof{[3, -1, 2]}", want: -1},
+		{name: "fold list", arg: "fold{(acc, x) -> acc*10 + x, 0, [1, 2, 3]}", want: 123},
+		{name: "sum of map", arg: "sum{map{x -> x^2, [1, 2, 3]}}", want: 14},
+		{name: "det", arg: "det{[1, 2; 3, 4]}", want: -2},
+		{name: "det with row swap", arg: "det{[0, 1, 2; 1, 0, 3; 4, -3, 8]}", want: -2},
+		{name: "det of singular matrix", arg: "det{[1, 2, 3; 4, 5, 6; 7, 8, 9]}", want: 0},
+		{name: "inv", arg: "inv{[4, 7; 2, 6]}[0][1]", want: -0.7},
+		{name: "solve", arg: "solve{[2, 1; 1, 3], [3, 5]}[1]", want: 1.4},
+		{name: "matmul of vectors", arg: "matmul{[1, 2, 3], [4, 5, 6]}", want: 32},
+		{name: "trace of identity", arg: "sum{k, 0, 3, identity{4}[k][k]}", want: 4},
+		{name: "sqrt of area", arg: "sqrt{16 m^2} / 1 m", want: 4},
+		{name: "sqrt of mixed units", arg: "sqrt{1 km*dm} / 1 m", want: 10},
+		{name: "pow of length", arg: "pow{3 cm, 2} / 1 cm^2", want: 9},
+		{name: "max of lengths", arg: "max{1 m, 50 cm, [2 ft, 3 ft]} / 1 cm", want: 100},
+		{name: "abs of force", arg: "abs{-2 kN} / 1 N", want: 2000},
+		{name: "hypot of lengths", arg: "hypot{3 m, 400 cm} / 1 m", want: 5},
+		{name: "sin of angle", arg: "sin{90 deg}", want: 1},
+		{name: "ln of dimensionless quantity", arg: "ln{1 km / 1 m} / ln{10}", want: 3},
+		{name: "sum of quantities", arg: "sum{k, 1, 4, k * 1 s} / 1 s", want: 10},
+		{name: "mean of quantities", arg: "mean{[1 h, 30 min]} / 1 min", want: 45},
+		{name: "dot of quantities", arg: "dot{[1 N, 2 N], [3 m, 4 m]} / 1 J", want: 11},
+		{name: "sqrt of length", arg: "sqrt{4 m}", wantErr: true},
+		{name: "sin of length", arg: "sin{1 m}", wantErr: true},
+		{name: "max of length and time", arg: "max{1 m, 1 s}", wantErr: true},
+		{name: "pow with unit exponent", arg: "pow{2, 1 m}", wantErr: true},
+		{name: "det of non-square matrix", arg: "det{[1, 2, 3; 4, 5, 6]}", wantErr: true},
+		{name: "inv of singular matrix", arg: "inv{[1, 2; 2, 4]}", wantErr: true},
+		{name: "solve with wrong shape", arg: "solve{[1, 0; 0, 1], [1, 2, 3]}", wantErr: true},
+		{name: "transpose of number", arg: "transpose{1}", wantErr: true},
+		{name: "identity of fraction", arg: "identity{1.5}", wantErr: true},
+		{name: "len of number", arg: "len{3}", wantErr: true},
+		{name: "mean of empty list", arg: "mean{[]}", wantErr: true},
+		{name: "dot of different lengths", arg: "dot{[1, 2], [1, 2, 3]}", wantErr: true},
+		{name: "map without list", arg: "map{x -> x, 3}", wantErr: true},
+		{name: "minof of empty list", arg: "minof{[]}", wantErr: true},
+		{name: "minof of empty range", arg: "minof{k, 1, 0, k}", wantErr: true},
+		{name: "loop variable is not a variable", arg: "sum{1, 1, 3, 2}", wantErr: true},
+		{name: "missing anonymous function", arg: "sum{k, 1, 3}", wantErr: true},
+		{name: "wrong number of parameters", arg: "fold{k -> k, 0, 1, 3}", wantErr: true},
+		{name: "range too long", arg: "sum{k, 1, 1e9, k}", wantErr: true},
+		{name: "anonymous function as operand", arg: "sqrt{x -> x}", wantErr: true},
+		{name: "too few arguments", arg: "pow{2}", wantErr: true},
+		{name: "too many arguments", arg: "sqrt{2, 3}", wantErr: true},
+		{name: "no arguments", arg: "min{}", wantErr: true},
+		{name: "domain error", arg: "sqrt{-1}", wantErr: true},
+		{name: "domain error log", arg: "acos{2}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Eval(tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Eval() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("Eval() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
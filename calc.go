@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 
 	"github.com/maxmoehl/calc/types"
 )
@@ -19,11 +20,48 @@ func SetDebug(b bool) {
 // as input and runs the lexer and parser to create a abstract syntax tree that
 // can be evaluated to get the final result. If any errors occur math.Nan and
 // the error are returned.
+//
+// Since there are no previous results, references like $0 cannot be used. Use
+// a Session to evaluate multiple expressions that build on each other.
 func Eval(input string) (float64, error) {
+	return eval(input, nil, types.NewEnv())
+}
+
+// EvalEnv works like Eval but looks up all variables in env. Assignments, e.g.
+// `x = 3*4`, store their result in env.
+func EvalEnv(input string, env *types.Env) (float64, error) {
+	return eval(input, nil, env)
+}
+
+// EvalValue works like EvalEnv but returns the result in the Mode of env, e.g. as
+// types.BigFloat if env uses types.ModeBigFloat. See types.Env.SetMode.
+func EvalValue(input string, env *types.Env) (types.Value, error) {
+	p, err := compile(input, nil, defaultRegistry)
+	if err != nil {
+		return nil, err
+	}
+	return p.EvalValue(env)
+}
+
+// eval compiles input and evaluates the resulting Program. Any references to
+// previous results are resolved using history, where history[0] is the most
+// recent result. Variables are looked up in env.
+func eval(input string, history []types.Value, env *types.Env) (float64, error) {
+	p, err := compile(input, history, defaultRegistry)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return p.Eval(env)
+}
+
+// compile runs the lexer and parser to create the abstract syntax tree for input.
+// Any references to previous results are resolved using history, where history[0]
+// is the most recent result. Macros are looked up in macros.
+func compile(input string, history []types.Value, macros *Registry) (*Program, error) {
 	// run lexer
 	tokens, err := tokenize(input)
 	if err != nil {
-		return math.NaN(), err
+		return nil, withInput(err, input)
 	}
 	if debug {
 		fmt.Println("the following instructions have been read by the lexer:")
@@ -32,6 +70,12 @@ func Eval(input string) (float64, error) {
 		}
 	}
 
+	// replace references with the results they point to
+	tokens, errs := resolveReferences(tokens, history)
+	if len(errs) > 0 {
+		return nil, withInput(errs[0], input)
+	}
+
 	// run parser
 	if debug {
 		fmt.Printf("the following abstract syntax tree has been generated by the parser.\n" +
@@ -40,32 +84,62 @@ func Eval(input string) (float64, error) {
 		fmt.Printf("%v -> %v\n", '-', "-")
 		fmt.Printf("%v -> %v\n", '*', "*")
 		fmt.Printf("%v -> %v\n", '/', "/")
+		fmt.Printf("%v -> %v\n", '^', "^")
 		fmt.Printf("%v -> %v\n", ',', ",")
 		fmt.Printf("%v -> %v\n", '(', "(")
 		fmt.Printf("%v -> %v\n", ')', ")")
 		fmt.Printf("%v -> %v\n", '{', "{")
 		fmt.Printf("%v -> %v\n", '}', "}")
+		fmt.Printf("%v -> %v\n", '[', "[")
+		fmt.Printf("%v -> %v\n", ']', "]")
+		fmt.Printf("%v -> %v\n", '=', "=")
 	}
-	var o types.Node
-	o, err = parse(tokens)
+	o, err := parseStatement(macros, tokens)
 	if err != nil {
-		return math.NaN(), err
+		return nil, withInput(err, input)
 	}
 	if debug {
 		b, _ := json.MarshalIndent(getAST(o), "", "  ")
 		fmt.Println(string(b))
 	}
+	return &Program{root: o, input: input}, nil
+}
 
-	// evaluate result
-	if o == nil {
-		return 0, nil
+// Check reports all errors in input that can be found without evaluating it, e.g.
+// syntax errors, unknown macros or wrong numbers of arguments. Unlike Eval, which
+// stops at the first error, Check continues after each error and returns all of
+// them, ordered by their position. If input is valid nil is returned.
+//
+// Errors that only occur during evaluation, like undefined variables or arguments
+// outside the domain of a macro, are not reported.
+func Check(input string) Errors {
+	return check(input, nil, defaultRegistry)
+}
+
+// check runs the lexer and parser in recovery mode and returns all errors found in
+// input. References are resolved using history and macros are looked up in macros.
+func check(input string, history []types.Value, macros *Registry) Errors {
+	tokens, errs := scan(input, true)
+	tokens, refErrs := resolveReferences(tokens, history)
+	errs = append(errs, refErrs...)
+	p := &parser{
+		tokens:  tokens,
+		macros:  macros,
+		recover: true,
 	}
-	var res float64
-	res, err = o.Eval()
-	if err != nil {
-		return math.NaN(), err
+	// in recovery mode all errors are recorded by the parser
+	_, _ = p.parseStatement()
+	errs = append(errs, p.errs...)
+	if len(errs) == 0 {
+		return nil
 	}
-	return res, nil
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Start < errs[j].Start
+	})
+	for i, err := range errs {
+		errs[i] = withInput(err, input).(*Error)
+	}
+	return errs
 }
 
 // printToken prints a single token in its correct string representation.
@@ -75,14 +149,22 @@ func printToken(t Token) {
 		fallthrough
 	case typeComma:
 		fallthrough
+	case typeAssign:
+		fallthrough
 	case typeBrace:
 		fallthrough
+	case typeBracket:
+		fallthrough
+	case typeSemicolon:
+		fallthrough
 	case typeParenthesis:
 		fmt.Printf("\t%s\t%s\n", getTypeStandardLength(t.Type()), string(t.Value().(rune)))
 	case typeLiteral:
-		fmt.Printf("\t%s\t%g\n", getTypeStandardLength(t.Type()), t.Value().(float64))
+		fmt.Printf("\t%s\t%v\n", getTypeStandardLength(t.Type()), t.Value())
 	case typeIdentifier:
 		fmt.Printf("\t%s\t%s\n", getTypeStandardLength(t.Type()), t.Value().(string))
+	case typeReference:
+		fmt.Printf("\t%s\t$%d\n", getTypeStandardLength(t.Type()), t.Value().(int))
 	}
 }
 
@@ -108,15 +190,49 @@ func getAST(in types.Node) (res map[string]interface{}) {
 	res = map[string]interface{}{}
 	if m, ok := in.(*macro); ok {
 		res["macro"] = map[string]string{
-			"_type": fmt.Sprintf("%T", m.m),
+			"_type":          fmt.Sprintf("%T", m.m),
 			"representation": fmt.Sprintf("%+v", m.m),
 		}
 	} else if l, ok := in.(*literal); ok {
 		res["value"] = l.value
+	} else if v, ok := in.(*variable); ok {
+		res["variable"] = v.name
+	} else if a, ok := in.(*assignment); ok {
+		res["assign"] = a.name
+		res["value"] = getAST(a.value)
+	} else if u, ok := in.(*unaryOperation); ok {
+		res["_operand"] = operatorString(u.operator)
+		res["operand"] = getAST(u.operand)
 	} else if o, ok := in.(*operation); ok {
-		res["_operand"] = string(o.operator)
+		res["_operand"] = operatorString(o.operator)
 		res["left"] = getAST(o.left)
 		res["right"] = getAST(o.right)
+	} else if o, ok := in.(*logicalOperation); ok {
+		res["_operand"] = operatorString(o.operator)
+		res["left"] = getAST(o.left)
+		res["right"] = getAST(o.right)
+	} else if c, ok := in.(*conditional); ok {
+		res["condition"] = getAST(c.condition)
+		res["then"] = getAST(c.then)
+		res["otherwise"] = getAST(c.otherwise)
+	} else if l, ok := in.(*list); ok {
+		elements := make([]map[string]interface{}, len(l.elements))
+		for i, e := range l.elements {
+			elements[i] = getAST(e)
+		}
+		res["list"] = elements
+	} else if x, ok := in.(*index); ok {
+		res["list"] = getAST(x.list)
+		res["index"] = getAST(x.index)
+	} else if q, ok := in.(*quantity); ok {
+		res["unit"] = q.unit.String()
+		res["value"] = getAST(q.value)
+	} else if c, ok := in.(*conversion); ok {
+		res["unit"] = c.unit.String()
+		res["convert"] = getAST(c.value)
+	} else if l, ok := in.(*lambda); ok {
+		res["parameters"] = l.parameters
+		res["body"] = getAST(l.body)
 	}
 	return
 }
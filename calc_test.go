@@ -1,7 +1,16 @@
 package calc
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/maxmoehl/calc/types"
 )
 
 func Test_run(t *testing.T) {
@@ -77,6 +86,366 @@ func Test_run(t *testing.T) {
 			want:    3,
 			wantErr: false,
 		},
+		{
+			name:    "division is left associative",
+			arg:     "8/2/2",
+			want:    2,
+			wantErr: false,
+		},
+		{
+			name:    "mixed multiplication and division",
+			arg:     "8/2*2",
+			want:    8,
+			wantErr: false,
+		},
+		{
+			name:    "simple exponentiation",
+			arg:     "2^3",
+			want:    8,
+			wantErr: false,
+		},
+		{
+			name:    "exponentiation alias",
+			arg:     "2**3",
+			want:    8,
+			wantErr: false,
+		},
+		{
+			name:    "exponentiation is right associative",
+			arg:     "2^3^2",
+			want:    512,
+			wantErr: false,
+		},
+		{
+			name:    "exponentiation binds tighter than multiplication",
+			arg:     "2+3*4^2*5",
+			want:    242,
+			wantErr: false,
+		},
+		{
+			name:    "exponentiation binds tighter than unary minus",
+			arg:     "-2^2",
+			want:    -4,
+			wantErr: false,
+		},
+		{
+			name:    "exponentiation of parentheses",
+			arg:     "(2^3)^2",
+			want:    64,
+			wantErr: false,
+		},
+		{
+			name:    "two operators in a row",
+			arg:     "2*^3",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "unary minus after multiplication",
+			arg:     "2*-3",
+			want:    -6,
+			wantErr: false,
+		},
+		{
+			name:    "unary minus after division",
+			arg:     "4/-2",
+			want:    -2,
+			wantErr: false,
+		},
+		{
+			name:    "unary minus in and after parentheses",
+			arg:     "(-3)*-1",
+			want:    3,
+			wantErr: false,
+		},
+		{
+			name:    "unary minus in exponent",
+			arg:     "2^-1",
+			want:    0.5,
+			wantErr: false,
+		},
+		{
+			name:    "unary minus in macro parameters",
+			arg:     "pow{-2, 3}",
+			want:    -8,
+			wantErr: false,
+		},
+		{
+			name:    "stacked signs",
+			arg:     "--3",
+			want:    3,
+			wantErr: false,
+		},
+		{
+			name:    "stacked mixed signs",
+			arg:     "1-+-3",
+			want:    4,
+			wantErr: false,
+		},
+		{
+			name:    "multiplication cannot be used as sign",
+			arg:     "2+*3",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "missing closing parenthesis",
+			arg:     "(1+2",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "missing opening parenthesis",
+			arg:     "1+2)",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "two operands in a row",
+			arg:     "max{1, 2 3}",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "trailing operator",
+			arg:     "2+",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "scientific notation",
+			arg:     "6.022e23 / 1e-9",
+			want:    6.022e32,
+			wantErr: false,
+		},
+		{
+			name:    "scientific notation with uppercase e and sign",
+			arg:     "2.5E+3 - 1e-1",
+			want:    2499.9,
+			wantErr: false,
+		},
+		{
+			name:    "leading decimal point",
+			arg:     ".5 + 1.",
+			want:    1.5,
+			wantErr: false,
+		},
+		{
+			name:    "digit separators",
+			arg:     "1_000_000 + 0.000_5",
+			want:    1000000.0005,
+			wantErr: false,
+		},
+		{
+			name:    "exponent without digits",
+			arg:     "1e+",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "base literals",
+			arg:     "0x1F + 0b1010 * 0o17",
+			want:    181,
+			wantErr: false,
+		},
+		{
+			name:    "base literal with separator",
+			arg:     "0XFF_FF",
+			want:    65535,
+			wantErr: false,
+		},
+		{
+			name:    "base literal with invalid digit",
+			arg:     "0o18",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "bitwise and",
+			arg:     "0b1100 & 0b1010",
+			want:    8,
+			wantErr: false,
+		},
+		{
+			name:    "bitwise or",
+			arg:     "0b1100 | 0b1010",
+			want:    14,
+			wantErr: false,
+		},
+		{
+			name:    "bitwise xor",
+			arg:     "0b1100 xor 0b1010",
+			want:    6,
+			wantErr: false,
+		},
+		{
+			name:    "bitwise not",
+			arg:     "~0b1010",
+			want:    -11,
+			wantErr: false,
+		},
+		{
+			name:    "shifts",
+			arg:     "1 << 10 >> 2",
+			want:    256,
+			wantErr: false,
+		},
+		{
+			name:    "arithmetic right shift",
+			arg:     "-9 >> 1",
+			want:    -5,
+			wantErr: false,
+		},
+		{
+			name:    "integer division",
+			arg:     "-7 // 2",
+			want:    -4,
+			wantErr: false,
+		},
+		{
+			name:    "modulo",
+			arg:     "-7 % 3",
+			want:    2,
+			wantErr: false,
+		},
+		{
+			name:    "modulo with negative divisor",
+			arg:     "7.5 % -2",
+			want:    -0.5,
+			wantErr: false,
+		},
+		{
+			name:    "bitwise precedence",
+			arg:     "1 | 6 xor 3 & 2 << 1 + 1",
+			want:    7,
+			wantErr: false,
+		},
+		{
+			name:    "integer division precedence",
+			arg:     "2 * 7 // 4 % 3",
+			want:    0,
+			wantErr: false,
+		},
+		{
+			name:    "bitwise operator with fraction",
+			arg:     "1.5 & 1",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "negative shift count",
+			arg:     "1 << -1",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "modulo by zero",
+			arg:     "1 % 0",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "comparisons",
+			arg:     "(1 < 2) + (2 <= 2) + (3 > 4) + (4 >= 4) + (5 == 5) + (5 != 5)",
+			want:    4,
+			wantErr: false,
+		},
+		{
+			name:    "comparison precedence",
+			arg:     "1 + 1 == 2",
+			want:    1,
+			wantErr: false,
+		},
+		{
+			name:    "logical operators",
+			arg:     "1 < 2 and not 2 < 1 or 0",
+			want:    1,
+			wantErr: false,
+		},
+		{
+			name:    "logical and binds tighter than or",
+			arg:     "1 or 1 and 0",
+			want:    1,
+			wantErr: false,
+		},
+		{
+			name:    "conditional operator",
+			arg:     "2 > 1 ? 10 : 20",
+			want:    10,
+			wantErr: false,
+		},
+		{
+			name:    "nested conditional operator",
+			arg:     "0 ? 1 : 0 ? 2 : 3",
+			want:    3,
+			wantErr: false,
+		},
+		{
+			name:    "conditional macro",
+			arg:     "if{1 > 2, 10, 20}",
+			want:    20,
+			wantErr: false,
+		},
+		{
+			name:    "short-circuit and",
+			arg:     "0 and x",
+			want:    0,
+			wantErr: false,
+		},
+		{
+			name:    "short-circuit or",
+			arg:     "1 or x",
+			want:    1,
+			wantErr: false,
+		},
+		{
+			name:    "lazy conditional",
+			arg:     "1 ? 1 : x + if{0, y, 2}",
+			want:    1,
+			wantErr: false,
+		},
+		{
+			name:    "constants",
+			arg:     "cos{pi} + 2*pi - tau + round{e*phi*1000}",
+			want:    4397,
+			wantErr: false,
+		},
+		{
+			name:    "assignment to constant",
+			arg:     "pi = 3",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "conditional without colon",
+			arg:     "1 ? 2",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "conditional macro with wrong arguments",
+			arg:     "if{1, 2}",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "index",
+			arg:     "[1, 2, 3][1] + [[1, 2], [3, 4]][-1][0]",
+			want:    5,
+			wantErr: false,
+		},
+		{
+			name:    "list result",
+			arg:     "[1, 2] * 2",
+			want:    0,
+			wantErr: true,
+		},
+		{
+			name:    "list as condition",
+			arg:     "[1, 2] ? 1 : 0",
+			want:    0,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -85,9 +454,1144 @@ func Test_run(t *testing.T) {
 				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if got != tt.want {
+			if !tt.wantErr && got != tt.want {
 				t.Errorf("run() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestSession_Eval(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "reference most recent result",
+			args: []string{"1", "$0+1"},
+			want: 2,
+		},
+		{
+			name: "reference older results",
+			args: []string{"1", "2", "3", "$2*$1+$0"},
+			want: 5,
+		},
+		{
+			name: "reference inside parentheses",
+			args: []string{"2", "3", "($1+$0)*2"},
+			want: 10,
+		},
+		{
+			name:    "reference without history",
+			args:    []string{"$0"},
+			wantErr: true,
+		},
+		{
+			name:    "reference out of range",
+			args:    []string{"1", "$1"},
+			wantErr: true,
+		},
+		{
+			name:    "reference without index",
+			args:    []string{"1", "$+1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSession()
+			var got float64
+			var err error
+			for _, arg := range tt.args {
+				got, err = s.Eval(arg)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Session.Eval() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Session.Eval() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		vars    map[string]float64
+		args    []string
+		want    float64
+		wantErr bool
+	}{
+		{
+			name: "read variable",
+			vars: map[string]float64{"x": 3},
+			args: []string{"x*2"},
+			want: 6,
+		},
+		{
+			name: "assign and read variable",
+			args: []string{"x = 3*4", "x / 2"},
+			want: 6,
+		},
+		{
+			name: "assignment returns value",
+			args: []string{"x = 2+3"},
+			want: 5,
+		},
+		{
+			name: "reassign variable",
+			args: []string{"x = 1", "x = x + 1", "x"},
+			want: 2,
+		},
+		{
+			name: "variable inside macro",
+			vars: map[string]float64{"x": 4},
+			args: []string{"pow{x, 2}"},
+			want: 16,
+		},
+		{
+			name: "pricing rule",
+			vars: map[string]float64{"qty": 150, "price": 20},
+			args: []string{"if{qty > 100, price*0.9, price}"},
+			want: 18,
+		},
+		{
+			name:    "undefined variable",
+			args:    []string{"y + 1"},
+			wantErr: true,
+		},
+		{
+			name:    "assignment inside expression",
+			args:    []string{"1 + x = 2"},
+			wantErr: true,
+		},
+		{
+			name:    "assignment without value",
+			args:    []string{"x ="},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			for n, v := range tt.vars {
+				env.Set(n, v)
+			}
+			var got float64
+			var err error
+			for _, arg := range tt.args {
+				got, err = EvalEnv(arg, env)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EvalEnv() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("EvalEnv() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnv_Nil(t *testing.T) {
+	var env *types.Env
+	if _, ok := env.Get("x"); ok {
+		t.Errorf("Env.Get() found a variable in a nil Env")
+	}
+	if names := env.Names(); len(names) != 0 {
+		t.Errorf("Env.Names() got = %v, want none", names)
+	}
+	if env.Mode() != types.ModeFloat || env.Precision() != types.DefaultPrecision {
+		t.Errorf("Env.Mode() and Env.Precision() should return the defaults")
+	}
+	env.Delete("x")
+	if got, err := EvalEnv("x = 2", env); err != nil || got != 2 {
+		t.Errorf("EvalEnv() = %v, %v, want 2", got, err)
+	}
+}
+
+func TestEvalValue_BigFloat(t *testing.T) {
+	tests := []struct {
+		name    string
+		prec    uint
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "decimal fractions",
+			args: []string{"0.1+0.2"},
+			want: "0.3",
+		},
+		{
+			name: "large integers",
+			args: []string{"12345678901234567890*10+1"},
+			want: "123456789012345678901",
+		},
+		{
+			name: "precision",
+			prec: 64,
+			args: []string{"1/3"},
+			want: "0.33333333333333333",
+		},
+		{
+			name: "integer exponent",
+			args: []string{"1.1^10 - 2^-2"},
+			want: "2.3437424601",
+		},
+		{
+			name: "variables and unary operators",
+			args: []string{"x = 0.1", "-x*3 + 1"},
+			want: "0.7",
+		},
+		{
+			name: "built-in macros",
+			args: []string{"round{2.5} + floor{-1.5} + max{0.1, 0.3} + abs{-0.2}"},
+			want: "1.5",
+		},
+		{
+			name: "constants",
+			args: []string{"2*pi - tau"},
+			want: "0",
+		},
+		{
+			name: "literal out of range for float64",
+			args: []string{"1e400 * 2"},
+			want: "2e+400",
+		},
+		{
+			name: "constant precision",
+			prec: 128,
+			args: []string{"pi"},
+			want: "3.14159265358979323846264338327950288",
+		},
+		{
+			name: "square root",
+			prec: 128,
+			args: []string{"sqrt{2}"},
+			want: "1.41421356237309504880168872420969808",
+		},
+		{
+			name: "macro without big.Float support",
+			args: []string{"exp{0} + 0.1"},
+			want: "1.1",
+		},
+		{
+			name:    "division by zero",
+			args:    []string{"1/(1-1)"},
+			wantErr: true,
+		},
+		{
+			name:    "domain",
+			args:    []string{"sqrt{-1}"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			env.SetMode(types.ModeBigFloat)
+			env.SetPrecision(tt.prec)
+			var got types.Value
+			var err error
+			for _, arg := range tt.args {
+				got, err = EvalValue(arg, env)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if _, ok := got.(types.BigFloat); !ok {
+				t.Errorf("EvalValue() got %T, want types.BigFloat", got)
+			}
+			if got.String() != tt.want {
+				t.Errorf("EvalValue() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalValue_Rational(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		decimal string
+		wantErr bool
+	}{
+		{
+			name:    "exact division",
+			args:    []string{"1/3*3"},
+			want:    "1",
+			decimal: "1.00",
+		},
+		{
+			name:    "fraction",
+			args:    []string{"7/3"},
+			want:    "7/3",
+			decimal: "2.33",
+		},
+		{
+			name:    "decimal literals",
+			args:    []string{"0.1+0.2-3/10"},
+			want:    "0",
+			decimal: "0.00",
+		},
+		{
+			name:    "integer exponent",
+			args:    []string{"(2/3)^2 + 2^-3"},
+			want:    "41/72",
+			decimal: "0.57",
+		},
+		{
+			name:    "literal out of range for float64",
+			args:    []string{"1e400 / 1e399"},
+			want:    "10",
+			decimal: "10.00",
+		},
+		{
+			name:    "variables",
+			args:    []string{"x = 1/6", "-x + 1"},
+			want:    "5/6",
+			decimal: "0.83",
+		},
+		{
+			name:    "built-in macros",
+			args:    []string{"round{-5/2} + floor{-7/3} + max{1/3, 0.3} + abs{-1/6}"},
+			want:    "-11/2",
+			decimal: "-5.50",
+		},
+		{
+			name:    "harmonic number",
+			args:    []string{"sum{k, 1, 10, 1/k}"},
+			want:    "7381/2520",
+			decimal: "2.93",
+		},
+		{
+			name:    "rational roots",
+			args:    []string{"(4/9)^(3/2) + sqrt{9/4} + pow{8, 1/3} + mod{7/2, 1}"},
+			want:    "116/27",
+			decimal: "4.30",
+		},
+		{
+			name:    "integer results of float64 macros",
+			args:    []string{"log{100} + exp{0} + hypot{3, 4}"},
+			want:    "8",
+			decimal: "8.00",
+		},
+		{
+			name:    "division by zero",
+			args:    []string{"1/(1-1)"},
+			wantErr: true,
+		},
+		{
+			name:    "irrational exponentiation",
+			args:    []string{"2^0.5"},
+			wantErr: true,
+		},
+		{
+			name:    "exponent too large",
+			args:    []string{"3^1e9"},
+			wantErr: true,
+		},
+		{
+			name:    "large exponent of one",
+			args:    []string{"(-1)^1e9 + 1^1e12"},
+			want:    "2",
+			decimal: "2.00",
+		},
+		{
+			name:    "irrational square root",
+			args:    []string{"sqrt{2}"},
+			wantErr: true,
+		},
+		{
+			name:    "irrational macro result",
+			args:    []string{"sin{1}"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			env.SetMode(types.ModeRational)
+			var got types.Value
+			var err error
+			for _, arg := range tt.args {
+				got, err = EvalValue(arg, env)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			r, ok := got.(types.Rat)
+			if !ok {
+				t.Fatalf("EvalValue() got %T, want types.Rat", got)
+			}
+			if r.String() != tt.want {
+				t.Errorf("EvalValue() got = %v, want %v", r, tt.want)
+			}
+			if r.Decimal(2) != tt.decimal {
+				t.Errorf("Rat.Decimal() got = %v, want %v", r.Decimal(2), tt.decimal)
+			}
+		})
+	}
+}
+
+func TestEvalValue_Complex(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    string
+		polar   string
+		wantErr bool
+	}{
+		{
+			name:  "square root of a negative number",
+			arg:   "sqrt{-1}",
+			want:  "1i",
+			polar: "1∠90°",
+		},
+		{
+			name:  "imaginary literals",
+			arg:   "3+4i",
+			want:  "3+4i",
+			polar: "5∠53.13010235415598°",
+		},
+		{
+			name:  "multiplication",
+			arg:   "(1+2i)*(3-1i)",
+			want:  "5+5i",
+			polar: "7.0710678118654755∠45°",
+		},
+		{
+			name:  "integer exponent",
+			arg:   "(2i)^2 + 2i^-1",
+			want:  "-4-0.5i",
+			polar: "4.031128874149275∠-172.8749836510982°",
+		},
+		{
+			name:  "logarithm",
+			arg:   "ln{-1}",
+			want:  "3.141592653589793i",
+			polar: "3.141592653589793∠90°",
+		},
+		{
+			name:  "complex macros",
+			arg:   "abs{3+4i} + re{2+3i} + im{2+3i} + conj{1i}",
+			want:  "10-1i",
+			polar: "10.04987562112089∠-5.710593137499643°",
+		},
+		{
+			name:  "real macro with real arguments",
+			arg:   "max{1, 2} + 1i",
+			want:  "2+1i",
+			polar: "2.23606797749979∠26.56505117707799°",
+		},
+		{
+			name:    "real macro with complex arguments",
+			arg:     "sin{1i}",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			env.SetMode(types.ModeComplex)
+			got, err := EvalValue(tt.arg, env)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			c, ok := got.(types.Complex)
+			if !ok {
+				t.Fatalf("EvalValue() got %T, want types.Complex", got)
+			}
+			if c.String() != tt.want {
+				t.Errorf("EvalValue() got = %v, want %v", c, tt.want)
+			}
+			if c.Polar() != tt.polar {
+				t.Errorf("Complex.Polar() got = %v, want %v", c.Polar(), tt.polar)
+			}
+		})
+	}
+}
+
+func TestEval_ImaginaryLiteral(t *testing.T) {
+	_, err := Eval("1 + 2i")
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("Eval() error = %v, want *Error", err)
+	}
+	if e.Kind != KindEval || e.Start != 4 || e.End != 6 {
+		t.Errorf("Eval() error = %v at %d-%d, want %v at 4-6", e.Kind, e.Start, e.End, KindEval)
+	}
+}
+
+func TestEvalValue_NotRational(t *testing.T) {
+	env := types.NewEnv()
+	env.SetMode(types.ModeRational)
+	for _, arg := range []string{"2^(1/2)", "sqrt{3/4}", "pow{2, 1/3}", "ln{2}"} {
+		if _, err := EvalValue(arg, env); !errors.Is(err, ErrNotRational) {
+			t.Errorf("EvalValue(%q) error = %v, want %v", arg, err, ErrNotRational)
+		}
+	}
+}
+
+func TestEval_ComplexResult(t *testing.T) {
+	env := types.NewEnv()
+	env.SetMode(types.ModeComplex)
+	if _, err := EvalEnv("2i", env); !errors.Is(err, ErrNotReal) {
+		t.Errorf("EvalEnv() error = %v, want %v", err, ErrNotReal)
+	}
+	got, err := EvalEnv("(2i)^2", env)
+	if err != nil || got != -4 {
+		t.Errorf("EvalEnv() = %v, %v, want -4", got, err)
+	}
+
+	s := NewSession()
+	s.Env().SetMode(types.ModeComplex)
+	if _, err = s.Eval("sqrt{-4}"); !errors.Is(err, ErrNotReal) {
+		t.Errorf("Session.Eval() error = %v, want %v", err, ErrNotReal)
+	}
+	if _, err = s.EvalValue("$0 * 1i"); err != nil {
+		t.Errorf("Session.EvalValue() error = %v, the complex result should be in the history", err)
+	}
+}
+
+func TestFormatBase(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   types.Value
+		base    int
+		want    string
+		wantErr bool
+	}{
+		{"hexadecimal", types.Float(255), 16, "0xff", false},
+		{"binary", types.Float(10), 2, "0b1010", false},
+		{"octal", types.Float(15), 8, "0o17", false},
+		{"decimal", types.Float(42), 10, "42", false},
+		{"negative", types.Float(-31), 16, "-0x1f", false},
+		{"large integer", types.NewBigFloat(new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(1), 100))), 16, "0x10000000000000000000000000", false},
+		{"integral fraction", types.NewRat(big.NewRat(16, 2)), 8, "0o10", false},
+		{"real complex number", types.Complex(5), 2, "0b101", false},
+		{"fraction", types.NewRat(big.NewRat(1, 2)), 16, "", true},
+		{"non-integer", types.Float(1.5), 2, "", true},
+		{"infinity", types.Float(math.Inf(1)), 16, "", true},
+		{"complex number", types.Complex(1i), 16, "", true},
+		{"unsupported base", types.Float(1), 3, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FormatBase(tt.value, tt.base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatBase() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FormatBase() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	_, err := FormatBase(types.Float(0.5), 16)
+	if !errors.Is(err, ErrNotInteger) {
+		t.Errorf("FormatBase() error = %v, want %v", err, ErrNotInteger)
+	}
+}
+
+func TestSession_EvalValue(t *testing.T) {
+	s := NewSession()
+	s.Env().SetMode(types.ModeBigFloat)
+	for _, arg := range []string{"0.1", "$0 + 0.2"} {
+		if _, err := s.EvalValue(arg); err != nil {
+			t.Fatalf("Session.EvalValue() error = %v", err)
+		}
+	}
+	got, err := s.EvalValue("$0 * 10 - 3")
+	if err != nil {
+		t.Fatalf("Session.EvalValue() error = %v", err)
+	}
+	if got.String() != "0" {
+		t.Errorf("Session.EvalValue() got = %v, want 0", got)
+	}
+
+	s = NewSession()
+	s.Env().SetMode(types.ModeRational)
+	if _, err = s.EvalValue("1/3"); err != nil {
+		t.Fatalf("Session.EvalValue() error = %v", err)
+	}
+	got, err = s.EvalValue("$0*3")
+	if err != nil {
+		t.Fatalf("Session.EvalValue() error = %v", err)
+	}
+	if got.String() != "1" {
+		t.Errorf("Session.EvalValue() got = %v, want 1", got)
+	}
+	history := s.HistoryValues()
+	if len(history) != 2 || history[0].String() != "1" || history[1].String() != "1/3" {
+		t.Errorf("Session.HistoryValues() got = %v, want [1 1/3]", history)
+	}
+}
+
+func TestProgram_Eval(t *testing.T) {
+	p, err := Compile("x*x + 2*x + 1")
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(x float64) {
+			defer wg.Done()
+			env := types.NewEnv()
+			for j := 0; j < 100; j++ {
+				env.Set("x", x)
+				got, err := p.Eval(env)
+				if err != nil {
+					t.Errorf("Program.Eval() error = %v", err)
+					return
+				}
+				if want := (x + 1) * (x + 1); got != want {
+					t.Errorf("Program.Eval() got = %v, want %v", got, want)
+					return
+				}
+			}
+		}(float64(i))
+	}
+	wg.Wait()
+
+	if _, err = p.Eval(nil); err == nil {
+		t.Errorf("Program.Eval() expected error for undefined variable")
+	}
+}
+
+// double is a macro used to test registering macros.
+type double struct {
+	value types.Node
+}
+
+func (d *double) Eval(env *types.Env) (float64, error) {
+	f, err := d.value.Eval(env)
+	return 2 * f, err
+}
+
+func newDouble(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) != 1 {
+		return nil, fmt.Errorf("expected one argument but got %d arguments", len(parameters))
+	}
+	return &double{parameters[0]}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register("double", newDouble); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	for _, name := range []string{"", "Double", "2dbl", "d_bl", "xor", "not"} {
+		if err := r.Register(name, newDouble); err == nil {
+			t.Errorf("Register() expected error for name '%s'", name)
+		}
+	}
+	if err := r.Register("nil", nil); err == nil {
+		t.Errorf("Register() expected error for nil function")
+	}
+
+	p, err := r.Compile("double{1+2}*2")
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	got, err := p.Eval(nil)
+	if err != nil {
+		t.Fatalf("Program.Eval() error = %v", err)
+	}
+	if got != 12 {
+		t.Errorf("Program.Eval() got = %v, want %v", got, 12)
+	}
+
+	got, err = r.NewSession().Eval("double{double{1}}")
+	if err != nil {
+		t.Fatalf("Session.Eval() error = %v", err)
+	}
+	if got != 4 {
+		t.Errorf("Session.Eval() got = %v, want %v", got, 4)
+	}
+
+	if _, err = Compile("double{1}"); err == nil {
+		t.Errorf("Compile() expected error for macro that is not in the default registry")
+	}
+}
+
+func TestRegistry_DefineConstant(t *testing.T) {
+	r := NewRegistry()
+	if err := r.DefineConstant("vat", "0.19"); err != nil {
+		t.Fatalf("DefineConstant() error = %v", err)
+	}
+	if err := r.DefineConstants("offset = -0x10, big=1_000"); err != nil {
+		t.Fatalf("DefineConstants() error = %v", err)
+	}
+	for _, name := range []string{"", "Vat", "2x", "and"} {
+		if err := r.DefineConstant(name, "1"); err == nil {
+			t.Errorf("DefineConstant() expected error for name '%s'", name)
+		}
+	}
+	for _, value := range []string{"", "x", "1+2", "1.2.3", "--1"} {
+		if err := r.DefineConstant("c", value); err == nil {
+			t.Errorf("DefineConstant() expected error for value '%s'", value)
+		}
+	}
+	if err := r.DefineConstants("a=1, b, c=x"); err == nil {
+		t.Errorf("DefineConstants() expected error for invalid definitions")
+	}
+	if _, ok := r.Constant("a"); !ok {
+		t.Errorf("DefineConstants() did not define the valid constant 'a'")
+	}
+
+	s := r.NewSession()
+	s.Env().Set("pi", 3)
+	got, err := s.Eval("100*(1+vat) + offset + big/1000 + round{pi*100}")
+	if err != nil {
+		t.Fatalf("Session.Eval() error = %v", err)
+	}
+	if got != 100*(1+0.19)-16+1+314 {
+		t.Errorf("Session.Eval() got = %v, want %v", got, 100*(1+0.19)-16+1+314)
+	}
+
+	_, err = s.Eval("vat = 0.2")
+	var e *Error
+	if !errors.As(err, &e) || e.Token != "vat" {
+		t.Errorf("Session.Eval() error = %v, want error for assignment to constant", err)
+	}
+
+	v, err := r.NewSession().EvalValue("vat")
+	if err != nil {
+		t.Fatalf("Session.EvalValue() error = %v", err)
+	}
+	if v.String() != "0.19" {
+		t.Errorf("Session.EvalValue() got = %v, want %v", v, "0.19")
+	}
+
+	if _, err = Eval("vat"); err == nil {
+		t.Errorf("Eval() expected error for constant that is not in the default registry")
+	}
+}
+
+func TestSession_Define(t *testing.T) {
+	tests := []struct {
+		name        string
+		definitions []string
+		arg         string
+		want        float64
+		wantErr     bool
+	}{
+		{
+			name:        "call function",
+			definitions: []string{"f{x, y} = x^2 + y"},
+			arg:         "f{3, 1} * 2",
+			want:        20,
+		},
+		{
+			name:        "function without parameters",
+			definitions: []string{"answer{} = 42"},
+			arg:         "answer{}",
+			want:        42,
+		},
+		{
+			name:        "recursion",
+			definitions: []string{"fact{n} = n <= 1 ? 1 : n*fact{n-1}"},
+			arg:         "fact{10}",
+			want:        3628800,
+		},
+		{
+			name:        "function calling function",
+			definitions: []string{"sq{x} = x*x", "hyp{a, b} = sqrt{sq{a} + sq{b}}"},
+			arg:         "hyp{3, 4}",
+			want:        5,
+		},
+		{
+			name:        "parameters shadow constants and variables",
+			definitions: []string{"f{pi, vat} = pi + vat"},
+			arg:         "f{1, 2}",
+			want:        3,
+		},
+		{
+			name:        "parameters are not visible in called functions",
+			definitions: []string{"g{} = x", "f{x} = g{}"},
+			arg:         "f{1}",
+			wantErr:     true,
+		},
+		{
+			name:        "recursion limit",
+			definitions: []string{"loop{x} = loop{x+1}"},
+			arg:         "loop{0}",
+			wantErr:     true,
+		},
+		{
+			name:        "anonymous function accesses parameters",
+			definitions: []string{"f{n} = sum{k -> k*n, 1, 3}"},
+			arg:         "f{2}",
+			want:        12,
+		},
+		{
+			name:        "range over parameter",
+			definitions: []string{"fact{n} = prod{k, 1, n, k}"},
+			arg:         "fact{6}",
+			want:        720,
+		},
+		{
+			name:        "wrong number of arguments",
+			definitions: []string{"f{x, y} = x + y"},
+			arg:         "f{1}",
+			wantErr:     true,
+		},
+		{
+			name:        "error in body",
+			definitions: []string{"f{x} = x + y"},
+			arg:         "f{1}",
+			wantErr:     true,
+		},
+		{
+			name:    "definitions cannot be evaluated",
+			arg:     "f{x} = x",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSession()
+			for _, d := range tt.definitions {
+				if err := s.Define(d); err != nil {
+					t.Fatalf("Session.Define() error = %v", err)
+				}
+			}
+			got, err := s.Eval(tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Session.Eval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Session.Eval() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	s := NewSession()
+	if err := s.Define("net{x} = x / (1 + vat)"); err != nil {
+		t.Fatalf("Session.Define() error = %v", err)
+	}
+	s.Env().Set("vat", 0.25)
+	if got, err := s.Eval("net{125}"); err != nil || got != 100 {
+		t.Errorf("Session.Eval() got = %v, %v, want %v", got, err, 100)
+	}
+
+	s = NewSession()
+	for _, d := range []string{"", "f = 1", "f{x} + 1", "f{x,} = x", "f{x, x} = x", "f{1} = 1", "f{x} =", "f{x} = g{x}", "f{x} = (x"} {
+		if err := s.Define(d); err == nil {
+			t.Errorf("Session.Define() expected error for %q", d)
+		}
+	}
+	if err := s.Define("f{x} = 2*x"); err != nil {
+		t.Fatalf("Session.Define() error = %v", err)
+	}
+	if err := s.Define("g{x} = f{x} + 1"); err != nil {
+		t.Fatalf("Session.Define() error = %v", err)
+	}
+	want := []string{"f{x} = 2*x", "g{x} = f{x} + 1"}
+	if got := s.Functions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Session.Functions() got = %v, want %v", got, want)
+	}
+	if _, err := NewSession().Eval("f{1}"); err == nil {
+		t.Errorf("Session.Eval() expected error for function of another session")
+	}
+	if err := s.Undefine("f"); err != nil {
+		t.Fatalf("Session.Undefine() error = %v", err)
+	}
+	if err := s.Undefine("sqrt"); err == nil {
+		t.Errorf("Session.Undefine() expected error for built-in macro")
+	}
+	if _, err := s.Eval("f{1}"); err == nil {
+		t.Errorf("Session.Eval() expected error for removed function")
+	}
+
+	r := NewRegistry()
+	if err := r.Define("h{x} = x"); err != nil {
+		t.Fatalf("Registry.Define() error = %v", err)
+	}
+	s = r.NewSession()
+	if got := s.Functions(); !reflect.DeepEqual(got, []string{"h{x} = x"}) {
+		t.Errorf("Session.Functions() got = %v, want [h{x} = x]", got)
+	}
+	if err := s.Undefine("h"); err == nil || !strings.Contains(err.Error(), "not been defined in this session") {
+		t.Errorf("Session.Undefine() error = %v, want error for function of the registry", err)
+	}
+	if err := r.Undefine("h"); err != nil {
+		t.Errorf("Registry.Undefine() error = %v", err)
+	}
+}
+
+func TestSession_Load(t *testing.T) {
+	s := NewSession()
+	err := s.Load(strings.NewReader("# tax rules\nvat = 0.19\n\ngross{x} = x * (1 + vat)\n"))
+	if err != nil {
+		t.Fatalf("Session.Load() error = %v", err)
+	}
+	got, err := s.Eval("gross{100}")
+	if err != nil {
+		t.Fatalf("Session.Eval() error = %v", err)
+	}
+	if got != 119 {
+		t.Errorf("Session.Eval() got = %v, want %v", got, 119)
+	}
+	if len(s.History()) != 1 {
+		t.Errorf("Session.Load() added results to the history: %v", s.History())
+	}
+
+	err = s.Load(strings.NewReader("x = 1\ny = (x\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("Session.Load() error = %v, want error in line 2", err)
+	}
+}
+
+func TestIsDefinition(t *testing.T) {
+	tests := map[string]bool{
+		"f{x} = x":        true,
+		"f{x, y} = x + y": true,
+		"f{} = 1":         true,
+		"f{x,} = x":       true,
+		"f{x} + 1":        false,
+		"x = 1":           false,
+		"f{g{x}} = 1":     true,
+		"1 + f{x}":        false,
+		"max{1, 2} == 2":  false,
+	}
+	for input, want := range tests {
+		if got := IsDefinition(input); got != want {
+			t.Errorf("IsDefinition(%q) got = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestEvalValue_List(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    types.Mode
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "literal", args: []string{"[1, 2+3, [4, 5]]"}, want: "[1, 5, [4, 5]]"},
+		{name: "empty list", args: []string{"[]"}, want: "[]"},
+		{name: "list and number", args: []string{"[1, 2, 3] * 2 - 1"}, want: "[1, 3, 5]"},
+		{name: "number and list", args: []string{"2 ^ [1, 2, 3]"}, want: "[2, 4, 8]"},
+		{name: "two lists", args: []string{"[1, 2] + [10, 20]"}, want: "[11, 22]"},
+		{name: "nested lists", args: []string{"[[1, 2], [3, 4]] * [10, 100]"}, want: "[[10, 20], [300, 400]]"},
+		{name: "comparison", args: []string{"[1, 5, 3] > 2"}, want: "[0, 1, 1]"},
+		{name: "unary operators", args: []string{"-[1, -2] + ~[0, 1]"}, want: "[-2, 0]"},
+		{name: "macro", args: []string{"round{[1.4, 2.6]}"}, want: "[1, 3]"},
+		{name: "macro with two lists", args: []string{"pow{[2, 3], [3, 2]}"}, want: "[8, 9]"},
+		{name: "variable", args: []string{"a = [4, 8, 15]", "a[1] + a[-1]"}, want: "23"},
+		{name: "map", args: []string{"map{x -> x^2, range{1, 4}}"}, want: "[1, 4, 9, 16]"},
+		{name: "sum of lists", args: []string{"sum{k, 1, 3, [k, k^2]}"}, want: "[6, 14]"},
+		{name: "rational", mode: types.ModeRational, args: []string{"[1, 2] / 3"}, want: "[1/3, 2/3]"},
+		{name: "big float", mode: types.ModeBigFloat, args: []string{"[0.1, 0.2] + 0.2"}, want: "[0.3, 0.4]"},
+		{name: "complex", mode: types.ModeComplex, args: []string{"sqrt{[-1, 4]}"}, want: "[1i, 2]"},
+		{name: "different lengths", args: []string{"[1, 2] * [1, 2, 3]"}, wantErr: true},
+		{name: "index out of range", args: []string{"[1, 2][-3]"}, wantErr: true},
+		{name: "index of number", args: []string{"3[0]"}, wantErr: true},
+		{name: "list index", args: []string{"[1, 2][[0]]"}, wantErr: true},
+		{name: "list as bound of range", args: []string{"sum{k, [1], 2, k}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			env.SetMode(tt.mode)
+			var got types.Value
+			var err error
+			for _, arg := range tt.args {
+				got, err = EvalValue(arg, env)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("EvalValue() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	s := NewSession()
+	if _, err := s.Eval("[1, 2]"); err == nil {
+		t.Errorf("Session.Eval() expected error for list result")
+	}
+	if got, err := s.EvalValue("$0 * 2"); err != nil || got.String() != "[2, 4]" {
+		t.Errorf("Session.EvalValue() got = %v, %v, want %v", got, err, "[2, 4]")
+	}
+
+	got, err := FormatBase(types.List{types.Float(10), types.List{types.Float(-1)}}, 16)
+	if err != nil || got != "[0xa, [-0x1]]" {
+		t.Errorf("FormatBase() got = %v, %v, want %v", got, err, "[0xa, [-0x1]]")
+	}
+}
+
+func TestEvalValue_Matrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    types.Mode
+		arg     string
+		want    string
+		wantErr bool
+	}{
+		{name: "literal", arg: "[1, 2; 3, 4]", want: "[[1, 2], [3, 4]]"},
+		{name: "single column", arg: "[1; 2]", want: "[[1], [2]]"},
+		{name: "element-wise", arg: "[1, 2; 3, 4] * 2 + [1, 2; 3, 4]", want: "[[3, 6], [9, 12]]"},
+		{name: "product", arg: "[1, 2; 3, 4] @ [5, 6; 7, 8]", want: "[[19, 22], [43, 50]]"},
+		{name: "product binds like *", arg: "2 * [1, 0; 0, 1] @ [1, 2; 3, 4] + 1", want: "[[3, 5], [7, 9]]"},
+		{name: "matrix and vector", arg: "[1, 2; 3, 4] @ [1, 1]", want: "[3, 7]"},
+		{name: "vector and matrix", arg: "[1, 1] @ [1, 2; 3, 4]", want: "[4, 6]"},
+		{name: "matmul", arg: "matmul{[1, 2, 3], [1; 2; 3]}", want: "[14]"},
+		{name: "transpose", arg: "transpose{[1, 2, 3; 4, 5, 6]}", want: "[[1, 4], [2, 5], [3, 6]]"},
+		{name: "transpose of list", arg: "transpose{[1, 2]}", want: "[[1], [2]]"},
+		{name: "identity", arg: "identity{2}", want: "[[1, 0], [0, 1]]"},
+		{name: "inverse", mode: types.ModeRational, arg: "inv{[4, 7; 2, 6]}", want: "[[3/5, -7/10], [-1/5, 2/5]]"},
+		{name: "inverse times matrix", mode: types.ModeRational, arg: "inv{[1, 2; 3, 4]} @ [1, 2; 3, 4]", want: "[[1, 0], [0, 1]]"},
+		{name: "exact determinant", mode: types.ModeRational, arg: "det{[1/2, 1/3; 1/4, 1/5]}", want: "1/60"},
+		{name: "solve", mode: types.ModeRational, arg: "solve{[2, 1; 1, 3], [3, 5]}", want: "[4/5, 7/5]"},
+		{name: "solve for matrix", mode: types.ModeRational, arg: "solve{[2, 0; 0, 4], [2, 4; 4, 8]}", want: "[[1, 2], [1, 2]]"},
+		{name: "big float determinant", mode: types.ModeBigFloat, arg: "det{[0.1, 0.2; 0.3, 0.4]}", want: "-0.02"},
+		{name: "complex determinant", mode: types.ModeComplex, arg: "det{[1i, 1; 1, 1i]}", want: "-2"},
+		{name: "nearly singular", arg: "inv{[1, 2, 3; 4, 5, 6; 7, 8, 9]}", wantErr: true},
+		{name: "entries of different scale", arg: "det{[1, 0; 0, 1e-15]}", want: "1e-15"},
+		{name: "inverse with small entries", arg: "inv{[2, 0; 0, 1e-20]} @ [4, 1e-20]", want: "[2, 1]"},
+		{name: "incompatible shapes", arg: "[1, 2; 3, 4] @ [1, 2, 3]", wantErr: true},
+		{name: "product of numbers", arg: "2 @ 3", wantErr: true},
+		{name: "missing row", arg: "[1, 2;]", wantErr: true},
+		{name: "ragged matrix", arg: "det{[[1, 2], [3]]}", wantErr: true},
+		{name: "identity too large", arg: "identity{1001}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			env.SetMode(tt.mode)
+			got, err := EvalValue(tt.arg, env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("EvalValue() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalValue_Units(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    types.Mode
+		arg     string
+		want    string
+		wantErr bool
+	}{
+		{name: "quantity", arg: "3 km", want: "3 km"},
+		{name: "compound unit", arg: "9.81 m/s^2", want: "9.81 m/s^2"},
+		{name: "negative exponent", arg: "50 s^-1", want: "50 s^-1"},
+		{name: "prefixed units", arg: "2 MW + 500 kW", want: "2.5 MW"},
+		{name: "sum uses left unit", arg: "3 km + 200 m", want: "3.2 km"},
+		{name: "difference", arg: "1 h - 15 min", want: "0.75 h"},
+		{name: "product", arg: "2 m * 3 m", want: "6 m^2"},
+		{name: "product converts same dimension", arg: "1 km * 500 m", want: "0.5 km^2"},
+		{name: "quotient", arg: "100 km / 2 h", want: "50 km/h"},
+		{name: "cancelled units are numbers", arg: "1 km / 1 m", want: "1000"},
+		{name: "power", arg: "(3 cm)^3", want: "27 cm^3"},
+		{name: "number times quantity", arg: "2 * 3 N", want: "6 N"},
+		{name: "unit after parentheses", arg: "(1 + 2) m", want: "3 m"},
+		{name: "negation", arg: "-(2 s)", want: "-2 s"},
+		{name: "conversion", arg: "60 mph in m/s", want: "26.8224 m/s"},
+		{name: "conversion with to", arg: "1 kWh to J", want: "3.6e+06 J"},
+		{name: "conversion of derived unit", arg: "1 N in kg*m/s^2", want: "1 kg*m/s^2"},
+		{name: "conversion binds loosest", arg: "2 km + 500 m in m", want: "2500 m"},
+		{name: "conversion of list", arg: "[1 m, 1 ft] in cm", want: "[100 cm, 30.48 cm]"},
+		{name: "angle", arg: "pi in deg", want: "180 deg"},
+		{name: "angle is a number", arg: "90 deg", want: "1.5707963267948966"},
+		{name: "comparison", arg: "1 mi > 1 km", want: "1"},
+		{name: "equality", arg: "100 cm == 1 m", want: "1"},
+		{name: "integer division", arg: "7 m // 2 m", want: "3"},
+		{name: "remainder", arg: "7 m % 200 cm", want: "1 m"},
+		{name: "sqrt halves dimensions", arg: "sqrt{9 m^2/s^2}", want: "3 m/s"},
+		{name: "sqrt in base units", arg: "sqrt{1 ha}", wantErr: true},
+		{name: "list of quantities", arg: "[1, 2] * 1 kg", want: "[1 kg, 2 kg]"},
+		{name: "exact", mode: types.ModeRational, arg: "60 mph in km/h", want: "301752/3125 km/h"},
+		{name: "exact prefix", mode: types.ModeRational, arg: "1 kcal in J", want: "4184 J"},
+		{name: "exact sum", mode: types.ModeRational, arg: "(1/3) h + 20 min", want: "2/3 h"},
+		{name: "big float", mode: types.ModeBigFloat, arg: "0.1 m + 0.2 m", want: "0.3 m"},
+		{name: "complex", mode: types.ModeComplex, arg: "sqrt{-4 m^2}", want: "2i m"},
+		{name: "different dimensions", arg: "1 kg + 1 s", wantErr: true},
+		{name: "number plus quantity", arg: "1 + 1 m", wantErr: true},
+		{name: "conversion to other dimension", arg: "3 m in s", wantErr: true},
+		{name: "conversion of number", arg: "3 in m", wantErr: true},
+		{name: "comparison of dimensions", arg: "1 m < 1 s", wantErr: true},
+		{name: "fractional unit exponent", arg: "(2 m)^0.5", wantErr: true},
+		{name: "exponent with unit", arg: "2^(1 s)", wantErr: true},
+		{name: "bitwise", arg: "4 m & 1", wantErr: true},
+		{name: "condition", arg: "1 m < 2 ? 1 : 0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := types.NewEnv()
+			env.SetMode(tt.mode)
+			got, err := EvalValue(tt.arg, env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvalValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("EvalValue() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Eval("3 km"); err == nil {
+		t.Errorf("Eval() expected error for quantity")
+	}
+	got, err := Eval("(1 km + 500 m) / 1 m")
+	if err != nil || got != 1500 {
+		t.Errorf("Eval() = %v, %v, want 1500", got, err)
+	}
+	s := NewSession()
+	if _, err := s.EvalValue("d = 2 m"); err != nil {
+		t.Fatalf("Session.EvalValue() error = %v", err)
+	}
+	v, err := s.EvalValue("$0 * d in cm^2")
+	if err != nil || v.String() != "40000 cm^2" {
+		t.Errorf("Session.EvalValue() = %v, %v, want 40000 cm^2", v, err)
+	}
+
+	// units take precedence over variables directly after a value
+	s = NewSession()
+	for _, stmt := range []string{"m = 3", "x = 4"} {
+		if _, err := s.Eval(stmt); err != nil {
+			t.Fatalf("Session.Eval() error = %v", err)
+		}
+	}
+	if v, err = s.EvalValue("(x) m"); err != nil || v.String() != "4 m" {
+		t.Errorf("Session.EvalValue() = %v, %v, want 4 m", v, err)
+	}
+	if v, err = s.EvalValue("(x) * m + x*m"); err != nil || v.String() != "24" {
+		t.Errorf("Session.EvalValue() = %v, %v, want 24", v, err)
+	}
+	if _, err = s.Eval("f{m} = 2 m"); err == nil {
+		t.Errorf("Session.Eval() expected error, parameters are not read as unit")
+	}
+	b, err := FormatBase(types.Quantity{Value: types.Float(255), Unit: types.Unit{{Symbol: "m", Exponent: 1}}}, 16)
+	if err != nil || b != "0xff m" {
+		t.Errorf("FormatBase() = %v, %v, want 0xff m", b, err)
+	}
+}
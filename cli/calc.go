@@ -4,71 +4,350 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 
 	"github.com/maxmoehl/calc"
+	"github.com/maxmoehl/calc/types"
 )
 
 func main() {
 	if _, found := os.LookupEnv("DEBUG"); found {
 		calc.SetDebug(true)
 	}
+	loadPlugins()
+	loadConstants()
 
 	interactive := flag.Bool("interactive", false, "start interactive mode")
+	precision := flag.Uint("precision", 0, "evaluate using arbitrary precision numbers with the given precision in bits")
+	exact := flag.Bool("exact", false, "evaluate using exact fractions")
+	digits := flag.Int("digits", -1, "print exact results as decimal numbers with the given number of digits instead of fractions")
+	complexMode := flag.Bool("complex", false, "evaluate using complex numbers")
+	polar := flag.Bool("polar", false, "print complex results in polar form")
+	base := flag.String("base", "dec", "print results in the given base: hex, bin, oct or dec")
+	load := flag.String("load", "", "load function definitions and variables from the given file")
 	flag.Parse()
 
+	env := types.NewEnv()
+	if *precision > 0 {
+		env.SetMode(types.ModeBigFloat)
+		env.SetPrecision(*precision)
+	}
+	if *exact {
+		env.SetMode(types.ModeRational)
+	}
+	if *complexMode {
+		env.SetMode(types.ModeComplex)
+	}
+	f := format{digits: *digits, polar: *polar}
+	var ok bool
+	f.base, ok = bases[*base]
+	if !ok {
+		printError(fmt.Errorf("unknown base %q, use one of %s", *base, baseNames()))
+		os.Exit(2)
+	}
+
+	session := calc.NewSession()
+	session.Env().SetMode(env.Mode())
+	session.Env().SetPrecision(env.Precision())
+	if *load != "" {
+		if err := loadFile(session, *load); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	}
+
 	if *interactive {
-		runInteractive()
+		runInteractive(session, f)
 		return
 	}
 
-	if len(os.Args) == 1 {
+	if flag.NArg() == 0 {
 
 		fmt.Println("Usage:")
 		fmt.Println("  either execute a single calculation:")
 		fmt.Println("    calc <mathematical expression>")
+		fmt.Println("  expressions starting with a minus sign have to be preceded by --:")
+		fmt.Println("    calc -- -2^2")
 		fmt.Println("  or start the interactive mode:")
 		fmt.Println("    calc -interactive")
+		fmt.Println("  use -precision to avoid rounding errors, e.g. 256 bits:")
+		fmt.Println("    calc -precision 256 0.1+0.2")
+		fmt.Println("  or -exact to calculate with fractions, optionally printed as decimal number:")
+		fmt.Println("    calc -exact -digits 5 1/3*3+1/7")
+		fmt.Println("  or -complex to calculate with complex numbers, optionally printed in polar form:")
+		fmt.Println("    calc -complex -polar 3+4i")
+		fmt.Println("  numbers can have units, which are converted using in:")
+		fmt.Println("    calc \"60 mph in m/s\"")
+		fmt.Println("  use -base to print integer results in another base:")
+		fmt.Println("    calc -base hex 0b1010*0o17")
+		fmt.Println("  use -load to load functions from a file, one definition per line:")
+		fmt.Println("    calc -load functions.txt f{3,1}")
 		fmt.Println()
 		fmt.Println("Loaded macros:")
 		fmt.Println("  " + strings.Join(calc.GetLoadedMacros(), ", "))
+		fmt.Println()
+		fmt.Println("Constants:")
+		fmt.Println("  " + strings.Join(calc.GetConstants(), ", "))
 		return
 	}
 
-	res, err := calc.Eval(strings.Join(os.Args[1:], ""))
+	input := strings.Join(flag.Args(), "")
+	res, err := session.EvalValue(input)
+	if err != nil {
+		printError(allErrors(err, session.Check(input)))
+		os.Exit(1)
+	}
+
+	out, err := f.value(res)
 	if err != nil {
 		printError(err)
 		os.Exit(1)
 	}
+	fmt.Println(out)
+}
 
-	fmt.Printf("%g\n", res)
+// loadPlugins loads the plugins from the directory returned by calc.PluginDir. If a
+// plugin cannot be loaded a warning is printed and the remaining plugins are used.
+// A missing plugin directory is silently ignored.
+func loadPlugins() {
+	dir := calc.PluginDir()
+	if dir == "" {
+		return
+	}
+	err := calc.LoadPlugins(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		printWarning(err)
+	}
+}
+
+// loadConstants defines the constants listed in the environment variable
+// CALC_CONSTANTS, e.g. "vat=0.19, discount=0.05". Invalid definitions are skipped
+// and a warning is printed.
+func loadConstants() {
+	definitions, found := os.LookupEnv("CALC_CONSTANTS")
+	if !found {
+		return
+	}
+	if err := calc.DefineConstants(definitions); err != nil {
+		printWarning(err)
+	}
+}
+
+// loadFile loads the file at path into session, see calc.Session.Load.
+func loadFile(session *calc.Session, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = session.Load(file); err != nil {
+		return fmt.Errorf("unable to load %s: %w", path, err)
+	}
+	return nil
 }
 
 // runInteractive launches the interactive mode. It can be exited by pressing CTRL + C
-// or typing `exit` and pressing enter.
-func runInteractive() {
+// or typing `exit` and pressing enter. All expressions are evaluated in session, so
+// previous results can be referenced using $0, $1, ... All results are formatted
+// using f. Lines of the form `name{parameters} = body` define functions, lines
+// starting with a colon are commands, see runCommand.
+func runInteractive(session *calc.Session, f format) {
 	s := bufio.NewScanner(os.Stdin)
 	var err error
 	var in string
-	var f float64
+	var v types.Value
 	for {
 		fmt.Print("> ")
-		s.Scan()
+		if !s.Scan() {
+			// stdin has been closed
+			fmt.Println()
+			return
+		}
 		in = s.Text()
 		if in == "exit" {
 			fmt.Println("bye")
 			os.Exit(0)
 		}
-		f, err = calc.Eval(in)
+		if strings.TrimSpace(in) == "" {
+			continue
+		}
+		if strings.HasPrefix(in, ":") {
+			f = runCommand(session, f, in[1:])
+			continue
+		}
+		if calc.IsDefinition(in) {
+			if err = session.Define(in); err != nil {
+				printError(err)
+			}
+			continue
+		}
+		v, err = session.EvalValue(in)
 		if err != nil {
-			printError(err)
+			printError(allErrors(err, session.Check(in)))
 			continue
 		}
-		fmt.Printf("%g\n", f)
+		printValue(f, v)
+	}
+}
+
+// runCommand executes a command of the interactive mode and returns the format
+// used for the following results. The available commands are:
+//
+//	:base <name>      print all following results in the base with the given name
+//	:<name> [expr]    print the result of expr, or the last result, once in the
+//	                  base with the given name, the result of expr is added to
+//	                  the history like any other result
+//	:functions        list all functions that have been defined
+//	:remove <name>    remove the function with the given name
+//	:load <file>      load function definitions and variables from a file
+//
+// where name is one of hex, bin, oct or dec.
+func runCommand(session *calc.Session, f format, cmd string) format {
+	name, arg := cmd, ""
+	if i := strings.IndexAny(cmd, " \t"); i >= 0 {
+		name, arg = cmd[:i], strings.TrimSpace(cmd[i+1:])
+	}
+	switch name {
+	case "base":
+		base, ok := bases[arg]
+		if !ok {
+			printError(fmt.Errorf("unknown base %q, use one of %s", arg, baseNames()))
+			return f
+		}
+		f.base = base
+		return f
+	case "functions":
+		for _, d := range session.Functions() {
+			fmt.Println(d)
+		}
+		return f
+	case "remove":
+		if err := session.Undefine(arg); err != nil {
+			printError(err)
+		}
+		return f
+	case "load":
+		if err := loadFile(session, arg); err != nil {
+			printError(err)
+		}
+		return f
+	}
+	base, ok := bases[name]
+	if !ok {
+		printError(fmt.Errorf("unknown command :%s, use :base, :functions, :remove, :load or one of :%s",
+			name, strings.Join(baseList, ", :")))
+		return f
+	}
+	var v types.Value
+	if arg == "" {
+		history := session.HistoryValues()
+		if len(history) == 0 {
+			printError(fmt.Errorf("there is no previous result to print in base %d", base))
+			return f
+		}
+		v = history[0]
+	} else {
+		var err error
+		v, err = session.EvalValue(arg)
+		if err != nil {
+			printError(allErrors(err, session.Check(arg)))
+			return f
+		}
+	}
+	once := f
+	once.base = base
+	printValue(once, v)
+	return f
+}
+
+// printValue prints v formatted using f, or the error if v cannot be formatted.
+func printValue(f format, v types.Value) {
+	out, err := f.value(v)
+	if err != nil {
+		printError(err)
+		return
 	}
+	fmt.Println(out)
+}
+
+// bases maps the names accepted by -base and the commands of the interactive
+// mode to the bases.
+var bases = map[string]int{
+	"hex": 16,
+	"bin": 2,
+	"oct": 8,
+	"dec": 10,
+}
+
+// baseList contains the names of all bases in the order they are listed in
+// messages.
+var baseList = []string{"hex", "bin", "oct", "dec"}
+
+// baseNames returns the names of all bases for error messages.
+func baseNames() string {
+	return strings.Join(baseList[:len(baseList)-1], ", ") + " or " + baseList[len(baseList)-1]
+}
+
+// format describes how results are printed.
+type format struct {
+	// digits is the number of digits after the decimal point that are printed for
+	// exact results. If it is negative, exact results are printed as fraction.
+	digits int
+	// polar enables the polar form for complex results.
+	polar bool
+	// base is the base results are printed in. Results in bases other than 10
+	// have to be integers, 0 is the same as 10.
+	base int
+}
+
+// value formats v for the output. An error is returned if v cannot be printed in
+// the base of f.
+func (f format) value(v types.Value) (string, error) {
+	if f.base != 0 && f.base != 10 {
+		return calc.FormatBase(v, f.base)
+	}
+	switch n := v.(type) {
+	case types.List:
+		elements := make([]string, len(n))
+		for i, e := range n {
+			s, err := f.value(e)
+			if err != nil {
+				return "", err
+			}
+			elements[i] = s
+		}
+		return "[" + strings.Join(elements, ", ") + "]", nil
+	case types.Quantity:
+		s, err := f.value(n.Value)
+		if err != nil {
+			return "", err
+		}
+		return s + " " + n.Unit.String(), nil
+	case types.Rat:
+		if f.digits >= 0 {
+			return n.Decimal(f.digits), nil
+		}
+	case types.Complex:
+		if f.polar {
+			return n.Polar(), nil
+		}
+	}
+	return v.String(), nil
+}
+
+// allErrors returns errs if the input contains more than one error that can be
+// found without evaluating it, so all of them can be fixed at once. Otherwise err,
+// the error returned by the evaluation, is returned.
+func allErrors(err error, errs calc.Errors) error {
+	if len(errs) > 1 {
+		return errs
+	}
+	return err
 }
 
 // printError takes an error and prints the value of error.Error() in red to the
@@ -76,3 +355,9 @@ func runInteractive() {
 func printError(err error) {
 	fmt.Println("\x1b[31m" + err.Error() + "\x1b[0m")
 }
+
+// printWarning takes an error and prints the value of error.Error() in yellow to
+// stderr, followed by a new line.
+func printWarning(err error) {
+	fmt.Fprintln(os.Stderr, "\x1b[33m"+err.Error()+"\x1b[0m")
+}
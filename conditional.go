@@ -0,0 +1,49 @@
+package calc
+
+import (
+	"fmt"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// conditional evaluates to then if condition is true, i.e. not zero, and to
+// otherwise if it is not. Only the selected branch is evaluated. It is created for
+// the conditional operator `condition ? then : otherwise` and the macro if.
+type conditional struct {
+	// condition selects the branch
+	condition types.Node
+	// then is evaluated if condition is true
+	then types.Node
+	// otherwise is evaluated if condition is false
+	otherwise types.Node
+	span
+}
+
+// newConditional implements the macro if{condition, then, otherwise}. Unlike the
+// other built-in macros it does not evaluate all of its parameters.
+func newConditional(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) != 3 {
+		return nil, fmt.Errorf("if: expected %s but got %d argument(s)", expectedArgs(3, 3), len(parameters))
+	}
+	return &conditional{parameters[0], parameters[1], parameters[2], span{}}, nil
+}
+
+func (c *conditional) Eval(env *types.Env) (float64, error) {
+	return evalFloat(c, env)
+}
+
+// EvalValue evaluates the condition and then the selected branch.
+func (c *conditional) EvalValue(env *types.Env) (types.Value, error) {
+	v, err := c.condition.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	b, err := truthy(v)
+	if err != nil {
+		return nil, wrapError(KindEval, spanOf(c.condition), err)
+	}
+	if b {
+		return c.then.EvalValue(env)
+	}
+	return c.otherwise.EvalValue(env)
+}
@@ -0,0 +1,124 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// builtinConstants contains the constants that are available in every Registry
+// created by NewRegistry. The values have 100 decimal places, which is enough for
+// DefaultPrecision of types.ModeBigFloat.
+var builtinConstants = map[string]string{
+	"pi":  "3.1415926535897932384626433832795028841971693993751058209749445923078164062862089986280348253421170679",
+	"e":   "2.7182818284590452353602874713526624977572470936999595749669676277240766303535475945713821785251664274",
+	"tau": "6.2831853071795864769252867665590057683943387987502116419498891846156328125724179972560696506842341359",
+	"phi": "1.6180339887498948482045868343656381177203091798057628621354486227052604628189024497072072041893911374",
+}
+
+// DefineConstant defines a constant in the default Registry, which is used by Eval,
+// Compile and NewSession. See Registry.DefineConstant for details.
+func DefineConstant(name, value string) error {
+	return defaultRegistry.DefineConstant(name, value)
+}
+
+// DefineConstants defines multiple constants in the default Registry. See
+// Registry.DefineConstants for details.
+func DefineConstants(definitions string) error {
+	return defaultRegistry.DefineConstants(definitions)
+}
+
+// GetConstants returns the names of all constants of the default Registry in
+// alphabetical order.
+func GetConstants() []string {
+	return defaultRegistry.ConstantNames()
+}
+
+// DefineConstant makes value available under name. Unlike variables, constants are
+// resolved when an expression is compiled and cannot be assigned to. The value has
+// to be written like a number in an expression, e.g. 0.19, 1_000 or 0xff, and can
+// be preceded by a minus sign. It keeps its precision in all modes. The name has to
+// be a valid identifier, if a constant with the same name already exists it is
+// replaced, this also applies to the built-in constants pi, e, tau and phi.
+func (r *Registry) DefineConstant(name, value string) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("unable to define constant '%s': name must be a valid identifier", name)
+	}
+	text, err := constantText(value)
+	if err != nil {
+		return fmt.Errorf("unable to define constant '%s': %w", name, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.constants[name] = text
+	return nil
+}
+
+// DefineConstants defines all constants in definitions, which is a comma separated
+// list of definitions of the form name=value, e.g. "vat=0.19, discount=0.05". Each
+// definition is handled like a call to DefineConstant. Invalid definitions are
+// skipped, the returned error describes all of them.
+func (r *Registry) DefineConstants(definitions string) error {
+	var msgs []string
+	for _, d := range strings.Split(definitions, ",") {
+		if strings.TrimSpace(d) == "" {
+			continue
+		}
+		i := strings.Index(d, "=")
+		if i < 0 {
+			msgs = append(msgs, fmt.Sprintf("unable to define constant: '%s' is not of the form name=value", strings.TrimSpace(d)))
+			continue
+		}
+		if err := r.DefineConstant(strings.TrimSpace(d[:i]), d[i+1:]); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// Constant returns the value of the constant name as it has been defined, but
+// normalized like a literal, and whether the constant exists.
+func (r *Registry) Constant(name string) (string, bool) {
+	r.mu.RLock()
+	v, ok := r.constants[name]
+	r.mu.RUnlock()
+	if !ok && r.parent != nil {
+		return r.parent.Constant(name)
+	}
+	return v, ok
+}
+
+// ConstantNames returns the names of all constants in alphabetical order.
+func (r *Registry) ConstantNames() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.constants))
+	for n := range r.constants {
+		names = append(names, n)
+	}
+	r.mu.RUnlock()
+	if r.parent != nil {
+		names = append(names, r.parent.ConstantNames()...)
+	}
+	return uniqueSorted(names)
+}
+
+// constantText checks that value is a single number and returns it in the same
+// format the lexer uses for literals, e.g. 0xff is returned as 255.
+func constantText(value string) (string, error) {
+	text := strings.TrimSpace(value)
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", strings.TrimSpace(text[1:])
+	}
+	tokens, err := tokenize(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid value '%s': %s", strings.TrimSpace(value), err.(*Error).Msg)
+	}
+	if len(tokens) != 1 || tokens[0].Type() != typeLiteral {
+		return "", fmt.Errorf("invalid value '%s': the value has to be a number", strings.TrimSpace(value))
+	}
+	return sign + tokens[0].Value().(string), nil
+}
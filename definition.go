@@ -0,0 +1,204 @@
+package calc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// maxCallDepth is the maximum number of nested function calls. It limits the
+// recursion of functions defined using Registry.Define.
+const maxCallDepth = 1000
+
+// userFunction is a function that has been defined by an expression of the form
+// `name{parameters} = body`, see Registry.Define.
+type userFunction struct {
+	name       string
+	parameters []string
+	body       types.Node
+	// definition is the input the function has been defined with, errors in the
+	// body refer to it
+	definition string
+}
+
+// newMacro implements types.NewMacro for the function.
+func (f *userFunction) newMacro(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) != len(f.parameters) {
+		return nil, fmt.Errorf("%s: expected %s but got %d argument(s)",
+			f.name, expectedArgs(len(f.parameters), len(f.parameters)), len(parameters))
+	}
+	return &call{f, parameters}, nil
+}
+
+// isParameter checks if name is a parameter of f. f may be nil.
+func (f *userFunction) isParameter(name string) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range f.parameters {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// call is a macro that invokes a userFunction.
+type call struct {
+	function  *userFunction
+	arguments []types.Node
+}
+
+func (c *call) Eval(env *types.Env) (float64, error) {
+	return evalFloat(c, env)
+}
+
+// EvalValue evaluates the arguments in env and the body of the function in a new
+// scope that only contains the parameters and the global variables of env.
+func (c *call) EvalValue(env *types.Env) (types.Value, error) {
+	if env.Depth() >= maxCallDepth {
+		return nil, fmt.Errorf("%s: maximum call depth of %d exceeded", c.function.name, maxCallDepth)
+	}
+	scope := env.NewCallScope()
+	for i, a := range c.arguments {
+		v, err := a.EvalValue(env)
+		if err != nil {
+			return nil, err
+		}
+		scope.SetValue(c.function.parameters[i], v)
+	}
+	v, err := c.function.body.EvalValue(scope)
+	if err != nil {
+		// the position of the error refers to the definition, not the input
+		// the function has been called from
+		return nil, withInput(err, c.function.definition)
+	}
+	return v, nil
+}
+
+// IsDefinition checks if input has the form of a function definition, i.e.
+// `name{parameters} = body`. Function definitions cannot be evaluated, they have
+// to be passed to Session.Define or Registry.Define.
+func IsDefinition(input string) bool {
+	tokens, _ := scan(input, true)
+	return definitionAssign(tokens) > 0
+}
+
+// definitionAssign returns the index of the '=' that separates the name and the
+// parameters of a function definition from its body. If tokens are not a function
+// definition -1 is returned.
+func definitionAssign(tokens []Token) int {
+	if len(tokens) < 2 || tokens[0].Type() != typeIdentifier || tokens[1].Value() != '{' {
+		return -1
+	}
+	depth := 0
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].Type() != typeBrace {
+			continue
+		}
+		if tokens[i].Value() == '{' {
+			depth++
+			continue
+		}
+		depth--
+		if depth == 0 {
+			if i+1 < len(tokens) && tokens[i+1].Type() == typeAssign {
+				return i + 1
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// Define defines a function in the default Registry, which is used by Eval, Compile
+// and NewSession. See Registry.Define for details.
+func Define(definition string) error {
+	return defaultRegistry.Define(definition)
+}
+
+// Define defines a function that can be invoked like any other macro. The
+// definition has the form `name{parameters} = body`, e.g. `f{x, y} = x^2 + y`.
+// The body can use the parameters, constants, global variables and macros,
+// including the function itself. The parameters are only visible in the body, not
+// in functions that are called from it. Recursion is limited to 1000 nested calls.
+// If a macro with the same name already exists it is replaced.
+func (r *Registry) Define(definition string) error {
+	tokens, err := tokenize(definition)
+	if err != nil {
+		return withInput(err, definition)
+	}
+	assign := definitionAssign(tokens)
+	if assign < 0 {
+		err := newError(KindSyntax, span{0, len([]rune(definition))},
+			"expected a function definition of the form name{parameters} = body")
+		return withInput(err, definition)
+	}
+	f, err := parseDefinition(r, tokens, assign)
+	if err != nil {
+		return withInput(err, definition)
+	}
+	f.definition = definition
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.macros[f.name] = f.newMacro
+	if r.functions == nil {
+		r.functions = make(map[string]*userFunction)
+	}
+	r.functions[f.name] = f
+	return nil
+}
+
+// Undefine removes the function name that has been defined using Define. Other
+// macros cannot be removed. If there is no such function an error is returned.
+// Functions of the Registry a session has been created from cannot be removed
+// using the session.
+func (r *Registry) Undefine(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.functions[name]; !ok {
+		if r.parent != nil {
+			// only the registries of sessions have a parent
+			if _, ok := r.parent.definitions()[name]; ok {
+				return fmt.Errorf("unable to remove function '%s': it has not been defined in this session", name)
+			}
+		}
+		return fmt.Errorf("unable to remove function '%s': no function with that name has been defined", name)
+	}
+	delete(r.functions, name)
+	delete(r.macros, name)
+	return nil
+}
+
+// Functions returns the definitions of all functions that have been defined using
+// Define, ordered by the names of the functions.
+func (r *Registry) Functions() []string {
+	functions := r.definitions()
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	definitions := make([]string, len(names))
+	for i, name := range names {
+		definitions[i] = functions[name]
+	}
+	return definitions
+}
+
+// definitions returns the definitions of all functions of r and its parents by the
+// names of the functions.
+func (r *Registry) definitions() map[string]string {
+	functions := make(map[string]string)
+	if r.parent != nil {
+		functions = r.parent.definitions()
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, f := range r.functions {
+		functions[name] = strings.TrimSpace(f.definition)
+	}
+	return functions
+}
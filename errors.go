@@ -0,0 +1,220 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// ErrorKind classifies an Error by the reason it occurred.
+type ErrorKind int
+
+const (
+	// KindSyntax is used if the input cannot be read by the lexer or parser.
+	KindSyntax ErrorKind = iota + 1
+	// KindUnknownMacro is used if an expression uses a macro that is not registered.
+	KindUnknownMacro
+	// KindArguments is used if a macro does not accept the arguments it has
+	// been invoked with, e.g. because the number of arguments is wrong.
+	KindArguments
+	// KindUndefinedVariable is used if an expression uses a variable that is not
+	// defined.
+	KindUndefinedVariable
+	// KindReference is used if a reference to a previous result cannot be resolved.
+	KindReference
+	// KindDomain is used if a macro is evaluated with arguments that are outside of
+	// its domain, e.g. the square root of a negative number. Macros signal this by
+	// returning an error that wraps types.ErrDomain.
+	KindDomain
+	// KindEval is used for all other errors that occur during evaluation.
+	KindEval
+	// KindNotInteger is used if an operator that is only defined for integers, e.g.
+	// the bitwise and &, is applied to a value that is not an integer. The error
+	// wraps ErrNotInteger.
+	KindNotInteger
+	// KindShape is used if the shapes of lists or matrices do not match, e.g. if
+	// lists of different lengths are added. The error wraps ErrLength or ErrShape.
+	KindShape
+	// KindDimension is used if the units of quantities do not match, e.g. if a
+	// length is added to a time. The error wraps ErrDimension.
+	KindDimension
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindSyntax:
+		return "syntax error"
+	case KindUnknownMacro:
+		return "unknown macro"
+	case KindArguments:
+		return "invalid arguments"
+	case KindUndefinedVariable:
+		return "undefined variable"
+	case KindReference:
+		return "invalid reference"
+	case KindDomain:
+		return "domain error"
+	case KindEval:
+		return "evaluation error"
+	case KindNotInteger:
+		return "not an integer"
+	case KindShape:
+		return "shape mismatch"
+	case KindDimension:
+		return "dimension mismatch"
+	default:
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+}
+
+// ErrNotInteger is returned, possibly wrapped, if a value has to be an integer but
+// is not, e.g. by FormatBase or the bitwise operators.
+var ErrNotInteger = errors.New("not an integer")
+
+// ErrNotRational is returned, possibly wrapped, if the result of a calculation in
+// types.ModeRational cannot be represented as fraction, e.g. the square root of 2.
+var ErrNotRational = errors.New("not a rational number")
+
+// ErrNotReal is returned, possibly wrapped, if a complex result with an imaginary
+// part is requested as float64, e.g. by Eval in types.ModeComplex.
+var ErrNotReal = errors.New("not a real number")
+
+// Error is the type of all errors returned while compiling or evaluating an
+// expression. It can be attributed to a specific part of the input. If the input is
+// known, Error renders the affected line and marks the part that caused the error.
+//
+// Use errors.As to access the details of an error returned by this package.
+type Error struct {
+	// Kind classifies the error.
+	Kind ErrorKind
+	// Start is the index of the first rune of the input that caused the error.
+	Start int
+	// End is the index after the last rune of the input that caused the error.
+	End int
+	// Token is the offending token as it appears in the input, e.g. the unknown
+	// character or the name of the undefined variable. It is empty if the error
+	// is not caused by a single token.
+	Token string
+	// Macro is the identifier of the macro that caused the error, if any.
+	Macro string
+	// Msg describes the error.
+	Msg string
+	// Hint is an optional suggestion on how to fix the error.
+	Hint string
+	// Input is the complete input the position refers to.
+	Input string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// newError creates an Error of kind for the part of the input described by s. The
+// message is formatted according to format.
+func newError(kind ErrorKind, s span, format string, a ...interface{}) *Error {
+	return &Error{
+		Kind:  kind,
+		Start: s.start,
+		End:   s.end,
+		Msg:   fmt.Sprintf(format, a...),
+	}
+}
+
+// evalKind returns the ErrorKind of err, which occurred during evaluation. It
+// classifies errors wrapping types.ErrDomain, ErrNotInteger, ErrLength, ErrShape
+// or ErrDimension, all other errors are of KindEval.
+func evalKind(err error) ErrorKind {
+	switch {
+	case errors.Is(err, types.ErrDomain):
+		return KindDomain
+	case errors.Is(err, ErrNotInteger):
+		return KindNotInteger
+	case errors.Is(err, ErrLength), errors.Is(err, ErrShape):
+		return KindShape
+	case errors.Is(err, ErrDimension):
+		return KindDimension
+	default:
+		return KindEval
+	}
+}
+
+// wrapError attributes err to the part of the input described by s. If err already
+// is an Error it is returned as is, since it already points to a more precise
+// position.
+func wrapError(kind ErrorKind, s span, err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	e := newError(kind, s, "%s", err.Error())
+	e.Err = err
+	return e
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s at position %d", e.Msg, e.Start+1)
+	if e.Input != "" {
+		b.WriteString("\n")
+		b.WriteString(snippet(e.Input, span{e.Start, e.End}))
+	}
+	if e.Hint != "" {
+		b.WriteString("\n\t")
+		b.WriteString(e.Hint)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors is a list of errors, it is returned if all errors of an expression are
+// reported at once, see Check.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// withInput adds input to err if it is an Error that does not know its input yet.
+// A copy is returned, so the original error is not modified.
+func withInput(err error, input string) error {
+	e, ok := err.(*Error)
+	if !ok || e.Input != "" {
+		return err
+	}
+	c := *e
+	c.Input = input
+	return &c
+}
+
+// snippet returns the line of input that contains s followed by a line that marks
+// the runes covered by s.
+func snippet(input string, s span) string {
+	symbols := []rune(input)
+	if s.start > len(symbols) {
+		s.start = len(symbols)
+	}
+	lineStart := s.start
+	for lineStart > 0 && symbols[lineStart-1] != '\n' {
+		lineStart--
+	}
+	lineEnd := s.start
+	for lineEnd < len(symbols) && symbols[lineEnd] != '\n' {
+		lineEnd++
+	}
+	end := s.end
+	if end > lineEnd {
+		end = lineEnd
+	}
+	markers := end - s.start
+	if markers < 1 {
+		markers = 1
+	}
+	return "\t" + string(symbols[lineStart:lineEnd]) + "\n\t" +
+		strings.Repeat(" ", s.start-lineStart) + strings.Repeat("^", markers)
+}
@@ -0,0 +1,368 @@
+package calc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{
+			name: "unknown character",
+			arg:  "1+#",
+			want: "unknown character '#' at position 3\n\t1+#\n\t  ^",
+		},
+		{
+			name: "unknown character with hint",
+			arg:  "1+X",
+			want: "unknown character 'X' at position 3\n\t1+X\n\t  ^\n\tonly lowercase letters can be used as part of an identifier",
+		},
+		{
+			name: "parentheses",
+			arg:  "2 * (1.5 & 1) + 1",
+			want: "1.5 is not an integer, the operands of & have to be integers at position 5\n\t2 * (1.5 & 1) + 1\n\t    ^^^^^^^^^",
+		},
+		{
+			name: "nested parentheses",
+			arg:  "((1 km) + (2 h)) * 2",
+			want: "1 km and 2 h cannot be combined with +: incompatible dimensions at position 1\n\t((1 km) + (2 h)) * 2\n\t^^^^^^^^^^^^^^^^",
+		},
+		{
+			name: "unexpected token",
+			arg:  "2 * 3 4",
+			want: "unexpected literal '4' at position 7\n\t2 * 3 4\n\t      ^",
+		},
+		{
+			name: "unknown unit",
+			arg:  "2 * 3 ms + 4 sx",
+			want: "unexpected identifier 'sx' at position 14\n\t2 * 3 ms + 4 sx\n\t             ^^\n\t'sx' is not a known unit",
+		},
+		{
+			name: "incompatible dimensions",
+			arg:  "3 km + 2 h",
+			want: "3 km and 2 h cannot be combined with +: incompatible dimensions at position 1\n\t3 km + 2 h\n\t^^^^^^^^^^",
+		},
+		{
+			name: "unexpected end",
+			arg:  "2 *",
+			want: "unexpected end of expression, expected an operand at position 4\n\t2 *\n\t   ^",
+		},
+		{
+			name: "missing closing parenthesis",
+			arg:  "2*(1+2",
+			want: "missing closing parenthesis for opening parenthesis at position 3\n\t2*(1+2\n\t  ^",
+		},
+		{
+			name: "unknown macro",
+			arg:  "1 + foo{2}",
+			want: "unknown macro identifier foo at position 5\n\t1 + foo{2}\n\t    ^^^",
+		},
+		{
+			name: "wrong number of arguments",
+			arg:  "1 + pow{2}",
+			want: "pow: expected 2 argument(s) but got 1 argument(s) at position 5\n\t1 + pow{2}\n\t    ^^^^^^",
+		},
+		{
+			name: "undefined variable",
+			arg:  "2 * (x + 1)",
+			want: "undefined variable 'x' at position 6\n\t2 * (x + 1)\n\t     ^",
+		},
+		{
+			name: "error in nested macro",
+			arg:  "max{1, sqrt{-4}}",
+			want: "sqrt: arguments [-4] are outside of the domain at position 8\n\tmax{1, sqrt{-4}}\n\t       ^^^^^^^^",
+		},
+		{
+			name: "multiple decimal points",
+			arg:  "1 + 1.2.3",
+			want: "number 1.2.3 contains more than one decimal point at position 5\n\t1 + 1.2.3\n\t    ^^^^^",
+		},
+		{
+			name: "misplaced digit separator",
+			arg:  "1__000",
+			want: "misplaced digit separator in number 1__000, '_' is only allowed between digits at position 1\n\t1__000\n\t^^^^^^",
+		},
+		{
+			name: "exponent without digits",
+			arg:  "2e",
+			want: "the exponent of number 2e has no digits at position 1\n\t2e\n\t^^",
+		},
+		{
+			name: "fractional exponent",
+			arg:  "2e1.5",
+			want: "the exponent of number 2e1.5 has to be an integer at position 1\n\t2e1.5\n\t^^^^^",
+		},
+		{
+			name: "number out of range",
+			arg:  "1e400",
+			want: "number 1e400 is out of range at position 1\n\t1e400\n\t^^^^^",
+		},
+		{
+			name: "base literal without digits",
+			arg:  "1 + 0x",
+			want: "hexadecimal number 0x has no digits at position 5\n\t1 + 0x\n\t    ^^",
+		},
+		{
+			name: "invalid digit in base literal",
+			arg:  "0b102",
+			want: "invalid digit '2' in binary number 0b102 at position 1\n\t0b102\n\t^^^^^",
+		},
+		{
+			name: "bitwise operator with fraction",
+			arg:  "3 | 0.5",
+			want: "0.5 is not an integer, the operands of | have to be integers at position 1\n\t3 | 0.5\n\t^^^^^^^",
+		},
+		{
+			name: "anonymous function outside of macro",
+			arg:  "x -> x",
+			want: "unexpected operator '->' at position 3\n\tx -> x\n\t  ^^\n\tanonymous functions can only be passed to macros, e.g. sum{k -> k^2, 1, 10}",
+		},
+		{
+			name: "error in anonymous function",
+			arg:  "sum{k -> k*y, 1, 3}",
+			want: "undefined variable 'y' at position 12\n\tsum{k -> k*y, 1, 3}\n\t           ^",
+		},
+		{
+			name: "index out of range",
+			arg:  "a = [1, 2, 3][3]",
+			want: "index 3 is out of range for a list of length 3 at position 5\n\ta = [1, 2, 3][3]\n\t    ^^^^^^^^^^^^",
+		},
+		{
+			name: "lists of different lengths",
+			arg:  "[1, 2] + [1, 2, 3]",
+			want: "lists of different lengths cannot be combined: the lengths are 2 and 3 at position 1\n\t[1, 2] + [1, 2, 3]\n\t^^^^^^^^^^^^^^^^^^",
+		},
+		{
+			name: "multiple lines",
+			arg:  "1 +\n2 3",
+			want: "unexpected literal '3' at position 7\n\t2 3\n\t  ^",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Eval(tt.arg)
+			if err == nil {
+				t.Fatalf("Eval() expected error")
+			}
+			if err.Error() != tt.want {
+				t.Errorf("Eval() error = %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name  string
+		arg   string
+		kind  ErrorKind
+		token string
+		macro string
+		start int
+		end   int
+	}{
+		{name: "unknown character", arg: "1+#", kind: KindSyntax, token: "#", start: 2, end: 3},
+		{name: "unexpected token", arg: "1+2)", kind: KindSyntax, token: ")", start: 3, end: 4},
+		{name: "unknown macro", arg: "foo{1}", kind: KindUnknownMacro, token: "foo", macro: "foo", start: 0, end: 3},
+		{name: "wrong arguments", arg: "2*pow{1}", kind: KindArguments, macro: "pow", start: 2, end: 8},
+		{name: "undefined variable", arg: "1+x", kind: KindUndefinedVariable, token: "x", start: 2, end: 3},
+		{name: "invalid reference", arg: "$1", kind: KindReference, token: "$1", start: 0, end: 2},
+		{name: "domain error", arg: "1+log{-1}", kind: KindDomain, macro: "log", start: 2, end: 9},
+		{name: "not an integer", arg: "1+(1.5 & 1)", kind: KindNotInteger, start: 2, end: 11},
+		{name: "complement of a fraction", arg: "~0.5", kind: KindNotInteger, start: 0, end: 4},
+		{name: "fractional index", arg: "[1, 2][1/2]", kind: KindNotInteger, start: 7, end: 10},
+		{name: "lists of different lengths", arg: "[1] + [1, 2]", kind: KindShape, start: 0, end: 12},
+		{name: "ragged matrix", arg: "[1, 2; 3]", kind: KindShape, start: 7, end: 8},
+		{name: "incompatible matrices", arg: "1 + det{[1, 2] @ [1; 2; 3]}", kind: KindShape, start: 8, end: 26},
+		{name: "singular matrix", arg: "inv{[0, 0; 0, 0]}", kind: KindDomain, macro: "inv", start: 0, end: 17},
+		{name: "incompatible dimensions", arg: "1 kg + 1 s", kind: KindDimension, start: 0, end: 10},
+		{name: "incompatible conversion", arg: "2 * (3 N in J)", kind: KindDimension, start: 4, end: 14},
+		{name: "function of a quantity", arg: "1 + exp{2 m}", kind: KindDimension, macro: "exp", start: 4, end: 12},
+		{name: "unknown unit", arg: "1 m in xyz", kind: KindSyntax, token: "xyz", start: 7, end: 10},
+		{name: "unit without number", arg: "2 * N", kind: KindSyntax, token: "N", start: 4, end: 5},
+		{name: "list result", arg: " [1, 2]", kind: KindEval, start: 1, end: 7},
+		{name: "quantity result", arg: "x = 3 km", kind: KindEval, start: 0, end: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Eval(tt.arg)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("Eval() error = %v, want *Error", err)
+			}
+			if e.Kind != tt.kind {
+				t.Errorf("Error.Kind = %v, want %v", e.Kind, tt.kind)
+			}
+			if e.Token != tt.token {
+				t.Errorf("Error.Token = %q, want %q", e.Token, tt.token)
+			}
+			if e.Macro != tt.macro {
+				t.Errorf("Error.Macro = %q, want %q", e.Macro, tt.macro)
+			}
+			if e.Start != tt.start || e.End != tt.end {
+				t.Errorf("Error position = %d-%d, want %d-%d", e.Start, e.End, tt.start, tt.end)
+			}
+			if e.Input != tt.arg {
+				t.Errorf("Error.Input = %q, want %q", e.Input, tt.arg)
+			}
+		})
+	}
+
+	_, err := Eval("sqrt{-1}")
+	if !errors.Is(err, types.ErrDomain) {
+		t.Errorf("Eval() error = %v, want to wrap %v", err, types.ErrDomain)
+	}
+	_, err = Eval("1 << 0.5")
+	if !errors.Is(err, ErrNotInteger) {
+		t.Errorf("Eval() error = %v, want to wrap %v", err, ErrNotInteger)
+	}
+	_, err = Eval("sum{[1, 2] - [1]}")
+	if !errors.Is(err, ErrLength) {
+		t.Errorf("Eval() error = %v, want to wrap %v", err, ErrLength)
+	}
+	_, err = Eval("det{[1, 2]}")
+	if errors.Is(err, ErrShape) {
+		t.Errorf("Eval() error = %v, want not to wrap %v", err, ErrShape)
+	}
+	_, err = Eval("det{[1, 2; 3, 4; 5, 6]}")
+	if !errors.Is(err, ErrShape) {
+		t.Errorf("Eval() error = %v, want to wrap %v", err, ErrShape)
+	}
+	_, err = Eval("sqrt{1 m^3}")
+	if !errors.Is(err, ErrDimension) {
+		t.Errorf("Eval() error = %v, want to wrap %v", err, ErrDimension)
+	}
+	_, err = NewSession().Eval("[1, 2]")
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindEval || e.Input != "[1, 2]" {
+		t.Errorf("Session.Eval() error = %#v, want *Error of kind %v with input", err, KindEval)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	type position struct {
+		kind  ErrorKind
+		start int
+	}
+	tests := []struct {
+		name string
+		arg  string
+		want []position
+	}{
+		{
+			name: "valid",
+			arg:  "max{1, 2} + (3*4)",
+			want: nil,
+		},
+		{
+			name: "multiple errors",
+			arg:  "1 + * 2 + (3 4) + foo{1}",
+			want: []position{{KindSyntax, 4}, {KindSyntax, 13}, {KindUnknownMacro, 18}},
+		},
+		{
+			name: "lexer and parser errors",
+			arg:  "1 # 2 + 1..2",
+			want: []position{{KindSyntax, 2}, {KindSyntax, 4}, {KindSyntax, 8}},
+		},
+		{
+			name: "resync at comma",
+			arg:  "max{1 2, 3 4} + pow{1}",
+			want: []position{{KindSyntax, 6}, {KindSyntax, 11}, {KindArguments, 16}},
+		},
+		{
+			name: "resync at closing parenthesis",
+			arg:  "(1 + ) * (2 3)",
+			want: []position{{KindSyntax, 5}, {KindSyntax, 12}},
+		},
+		{
+			name: "resync at closing brace",
+			arg:  "min{1, *} + sqrt{-}",
+			want: []position{{KindSyntax, 7}, {KindSyntax, 18}},
+		},
+		{
+			name: "stray closing parenthesis",
+			arg:  "1 + ) + 2 +",
+			want: []position{{KindSyntax, 4}, {KindSyntax, 11}},
+		},
+		{
+			name: "unclosed macro inside parenthesis",
+			arg:  "(max{1, 2) + 3",
+			want: []position{{KindSyntax, 1}, {KindSyntax, 9}},
+		},
+		{
+			name: "anonymous function",
+			arg:  "fold{(a, a) -> a + , 0, 1, 2} + *",
+			want: []position{{KindSyntax, 9}, {KindSyntax, 19}, {KindSyntax, 32}},
+		},
+		{
+			name: "units",
+			arg:  "1 m^x + 2 Q + (3 in",
+			want: []position{{KindSyntax, 4}, {KindSyntax, 10}, {KindSyntax, 14}, {KindSyntax, 19}},
+		},
+		{
+			name: "matrix",
+			arg:  "[1, 2; 3] + [1 2; *]",
+			want: []position{{KindShape, 7}, {KindSyntax, 15}, {KindSyntax, 18}},
+		},
+		{
+			name: "list",
+			arg:  "[1 2, 3] + [4, *][0 5] + [6",
+			want: []position{{KindSyntax, 3}, {KindSyntax, 15}, {KindSyntax, 20}, {KindSyntax, 25}},
+		},
+		{
+			name: "invalid reference",
+			arg:  "$0 + $1",
+			want: []position{{KindReference, 0}, {KindReference, 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Check(tt.arg)
+			var got []position
+			for _, err := range errs {
+				if err.Input != tt.arg {
+					t.Errorf("Check() error input = %q, want %q", err.Input, tt.arg)
+				}
+				got = append(got, position{err.Kind, err.Start})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+			if len(tt.want) == 0 {
+				return
+			}
+			// Eval still stops at the first error, which is one of the reported ones
+			_, err := Eval(tt.arg)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("Eval() error = %v, want *Error", err)
+			}
+			for _, p := range tt.want {
+				if p == (position{e.Kind, e.Start}) {
+					return
+				}
+			}
+			t.Errorf("Eval() error = %v at %d, want one of %v", e.Kind, e.Start, tt.want)
+		})
+	}
+}
+
+func TestSession_Check(t *testing.T) {
+	s := NewSession()
+	if _, err := s.Eval("1"); err != nil {
+		t.Fatalf("Eval() error = %v", err)
+	}
+	if errs := s.Check("$0 + 1"); errs != nil {
+		t.Errorf("Check() = %v, want nil", errs)
+	}
+	if errs := s.Check("$1 + $0 +"); len(errs) != 2 {
+		t.Errorf("Check() = %v, want 2 errors", errs)
+	}
+}
@@ -0,0 +1,161 @@
+package calc
+
+import (
+	"fmt"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// lambda is an anonymous function of the form `x -> body` or `(x, y) -> body`. It
+// can only be passed to macros, which invoke it using Call, see types.Lambda.
+type lambda struct {
+	parameters []string
+	body       types.Node
+	span
+}
+
+// Parameters implements types.Lambda.
+func (l *lambda) Parameters() []string {
+	return l.parameters
+}
+
+// Call evaluates the body in a new scope of env that binds the parameters to args.
+// Unlike the functions created by Registry.Define, the body can access the
+// variables of env, e.g. the parameters of an enclosing function.
+func (l *lambda) Call(env *types.Env, args []types.Value) (types.Value, error) {
+	if len(args) != len(l.parameters) {
+		return nil, newError(KindArguments, l.span, "anonymous function: expected %s but got %d argument(s)",
+			expectedArgs(len(l.parameters), len(l.parameters)), len(args))
+	}
+	if env.Depth() >= maxCallDepth {
+		return nil, newError(KindEval, l.span, "anonymous function: maximum call depth of %d exceeded", maxCallDepth)
+	}
+	scope := env.NewScope()
+	for i, a := range args {
+		scope.SetValue(l.parameters[i], a)
+	}
+	return l.body.EvalValue(scope)
+}
+
+func (l *lambda) Eval(env *types.Env) (float64, error) {
+	return evalFloat(l, env)
+}
+
+// EvalValue always fails, anonymous functions do not have a value.
+func (l *lambda) EvalValue(*types.Env) (types.Value, error) {
+	err := newError(KindEval, l.span, "an anonymous function cannot be evaluated")
+	err.Hint = "anonymous functions can only be passed to macros like fold{(acc, k) -> acc*k, 1, 1, 5}"
+	return nil, err
+}
+
+// isLambda checks if an anonymous function starts at the next token, i.e. the
+// tokens have the form `x ->` or `(x, y) ->`.
+func (p *parser) isLambda() bool {
+	i := p.i
+	if i < len(p.tokens) && p.tokens[i].Type() == typeIdentifier {
+		i++
+	} else if i < len(p.tokens) && p.tokens[i].Value() == '(' {
+		i++
+		for i < len(p.tokens) && p.tokens[i].Value() != ')' {
+			if p.tokens[i].Type() != typeIdentifier && p.tokens[i].Type() != typeComma {
+				return false
+			}
+			i++
+		}
+		i++
+	} else {
+		return false
+	}
+	return i < len(p.tokens) && p.tokens[i].Type() == typeOperator && p.tokens[i].Value() == '→'
+}
+
+// parseLambda parses an anonymous function, isLambda has to be checked first. The
+// parameters are in scope while the body is parsed.
+func (p *parser) parseLambda() (types.Node, error) {
+	start := p.next()
+	var parameters []Token
+	if start.Type() == typeIdentifier {
+		parameters = []Token{start}
+	} else {
+		for t := p.next(); t.Value() != ')'; t = p.next() {
+			parameters = append(parameters, t)
+		}
+	}
+	l := &lambda{}
+	for i, t := range parameters {
+		var err *Error
+		if i%2 == 1 {
+			if t.Type() != typeComma {
+				err = newError(KindSyntax, spanOf(t), "expected ',' between parameters but got %s '%s'", t.Type(), tokenString(t))
+			}
+		} else if t.Type() != typeIdentifier {
+			err = newError(KindSyntax, spanOf(t), "expected a parameter name but got %s '%s'", t.Type(), tokenString(t))
+		} else if name := t.Value().(string); l.isParameter(name) {
+			err = newError(KindSyntax, spanOf(t), "duplicate parameter '%s'", name)
+			err.Token = name
+		} else {
+			l.parameters = append(l.parameters, name)
+		}
+		if err != nil {
+			// in recovery mode the body is still parsed
+			if _, err := p.fail(err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(parameters) > 0 && len(parameters)%2 == 0 {
+		last := parameters[len(parameters)-1]
+		if _, err := p.fail(newError(KindSyntax, spanOf(last), "expected a parameter name after ','")); err != nil {
+			return nil, err
+		}
+	}
+	arrow := p.next()
+	if p.done() {
+		return p.fail(newError(KindSyntax, spanOf(arrow), "missing body of anonymous function"))
+	}
+	locals := p.locals
+	p.locals = append(p.locals[:len(p.locals):len(p.locals)], l.parameters...)
+	defer func() { p.locals = locals }()
+	body, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	l.body = body
+	l.span = spanOf(start).join(spanOf(body))
+	return l, nil
+}
+
+// isParameter checks if name is a parameter of l.
+func (l *lambda) isParameter(name string) bool {
+	for _, p := range l.parameters {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocal checks if name is a parameter of an anonymous function whose body is
+// being parsed.
+func (p *parser) isLocal(name string) bool {
+	for _, l := range p.locals {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lambdaParameter returns the parameter at index i as a types.Lambda with arity
+// parameters. It is used by macros that accept an anonymous function.
+func lambdaParameter(name string, parameters []types.Node, i, arity int) (types.Lambda, error) {
+	l, ok := parameters[i].(types.Lambda)
+	if !ok {
+		return nil, fmt.Errorf("%s: argument %d has to be an anonymous function", name, i+1)
+	}
+	if len(l.Parameters()) != arity {
+		return nil, fmt.Errorf("%s: the anonymous function has to take %d parameter(s) but takes %d",
+			name, arity, len(l.Parameters()))
+	}
+	return l, nil
+}
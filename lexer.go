@@ -1,121 +1,426 @@
 package calc
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"math/big"
 	"strconv"
+	"strings"
+	"unicode"
 )
 
 var (
 	typeOperator    = "operator"
 	typeParenthesis = "parenthesis"
 	typeBrace       = "brace"
+	typeBracket     = "bracket"
 	typeComma       = "comma"
 	typeWhitespace  = "whitespace"
 	typeLiteral     = "literal"
 	typeIdentifier  = "identifier"
+	typeReference   = "reference"
+	typeAssign      = "assign"
+	typeColon       = "colon"
+	typeSemicolon   = "semicolon"
 )
 
 // validRunes maps the type identifier for each allowed type to the runes it can consist of
 var validRunes = map[string][]rune{
-	typeOperator:    {'+', '-', '*', '/'},
+	typeOperator:    {'+', '-', '*', '/', '^', '%', '&', '|', '~', '<', '>', '?', '@'},
 	typeParenthesis: {'(', ')'},
 	typeBrace:       {'{', '}'},
+	typeBracket:     {'[', ']'},
 	typeComma:       {','},
 	typeWhitespace:  {' ', '\n'},
 	typeLiteral:     {'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '0', '.'},
 	typeIdentifier:  {'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 'j', 'k', 'l', 'm', 'n', 'o', 'p', 'q', 'r', 's', 't', 'u', 'v', 'w', 'x', 'y', 'z'},
+	typeReference:   {'$'},
+	typeAssign:      {'='},
+	typeColon:       {':'},
+	typeSemicolon:   {';'},
+}
+
+// longOperators contains the operators that consist of more than one rune and the
+// rune that represents them in Tokens and Nodes.
+var longOperators = []struct {
+	text   string
+	symbol rune
+}{
+	{"**", '^'}, // ** is an alias for ^
+	{"//", '÷'},
+	{"<<", '≪'},
+	{">>", '≫'},
+	{"==", '≡'},
+	{"!=", '≠'},
+	{"<=", '≤'},
+	{">=", '≥'},
+	{"->", '→'},
+}
+
+// keywordOperators contains the operators that are written like identifiers and the
+// rune that represents them in Tokens and Nodes.
+var keywordOperators = map[string]rune{
+	"xor": '⊕',
+	"and": '∧',
+	"or":  '∨',
+	"not": '¬',
+	"in":  '∈',
+	"to":  '↦',
+}
+
+// operatorString returns the operator represented by symbol as it is written in
+// the input.
+func operatorString(symbol rune) string {
+	if isOfType(symbol, typeOperator) {
+		return string(symbol)
+	}
+	for _, op := range longOperators {
+		if op.symbol == symbol {
+			return op.text
+		}
+	}
+	for text, s := range keywordOperators {
+		if s == symbol {
+			return text
+		}
+	}
+	return string(symbol)
+}
+
+// readLongOperator checks if one of longOperators starts at index i and returns
+// its Token.
+func readLongOperator(symbols []rune, i int) (Token, bool) {
+	for _, op := range longOperators {
+		text := []rune(op.text)
+		if i+len(text) <= len(symbols) && string(symbols[i:i+len(text)]) == op.text {
+			return token{typeOperator, op.symbol, span{i, i + len(text)}}, true
+		}
+	}
+	return nil, false
 }
 
 // tokenize takes a string and creates a list of Token. In most cases each token
 // consists of the type identifier and the rune that was detected. Literals and
 // identifier have to be read by the external functions readIdentifier and
-// readLiteral.
+// readLiteral. tokenize stops at the first error.
 func tokenize(input string) ([]Token, error) {
+	tokens, errs := scan(input, false)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return tokens, nil
+}
+
+// scan does the actual work for tokenize. If recover is true, scan does not stop
+// at the first error but skips the offending symbols and continues with the rest
+// of the input. Literals and references that cannot be read are replaced by a
+// literal with the value NaN, so the parser can continue as well. All errors are
+// returned together with the tokens.
+func scan(input string, recover bool) ([]Token, Errors) {
 	symbols := []rune(input)
 	var t Token
 	var err error
+	var errs Errors
 	var tokens []Token
 	var s rune
 
 	for i := 0; i < len(symbols); i++ {
 		s = symbols[i]
 
-		if isOfType(s, typeOperator) {
-			tokens = append(tokens, token{typeOperator, s})
+		if op, ok := readLongOperator(symbols, i); ok {
+			tokens = append(tokens, op)
+			i = op.End() - 1
+		} else if isOfType(s, typeOperator) {
+			tokens = append(tokens, token{typeOperator, s, span{i, i + 1}})
 		} else if isOfType(s, typeParenthesis) {
-			tokens = append(tokens, token{typeParenthesis, s})
+			tokens = append(tokens, token{typeParenthesis, s, span{i, i + 1}})
 		} else if isOfType(s, typeBrace) {
-			tokens = append(tokens, token{typeBrace, s})
+			tokens = append(tokens, token{typeBrace, s, span{i, i + 1}})
+		} else if isOfType(s, typeBracket) {
+			tokens = append(tokens, token{typeBracket, s, span{i, i + 1}})
 		} else if isOfType(s, typeComma) {
-			tokens = append(tokens, token{typeComma, s})
+			tokens = append(tokens, token{typeComma, s, span{i, i + 1}})
+		} else if isOfType(s, typeAssign) {
+			tokens = append(tokens, token{typeAssign, s, span{i, i + 1}})
+		} else if isOfType(s, typeColon) {
+			tokens = append(tokens, token{typeColon, s, span{i, i + 1}})
+		} else if isOfType(s, typeSemicolon) {
+			tokens = append(tokens, token{typeSemicolon, s, span{i, i + 1}})
 		} else if isOfType(s, typeWhitespace) {
-			// do nothing, if needed at some point these can also be read to give precise locations
-			// of certain symbols, e.g. in case of an error
+			// do nothing, the position of every token is stored in the token itself
 		} else if isOfType(s, typeLiteral) {
 			t, i, err = readLiteral(symbols, i)
-			tokens = append(tokens, t)
+			tokens = appendToken(tokens, t, err)
+		} else if u, j, ok := readUnit(symbols, i); ok {
+			tokens = append(tokens, u)
+			i = j
 		} else if isOfType(s, typeIdentifier) {
 			t, i = readIdentifier(symbols, i)
+			if op, ok := keywordOperators[t.Value().(string)]; ok {
+				t = token{typeOperator, op, spanOf(t)}
+			}
 			tokens = append(tokens, t)
+		} else if isOfType(s, typeReference) {
+			t, i, err = readReference(symbols, i)
+			tokens = appendToken(tokens, t, err)
 		} else {
 			err = unknownSymbol(symbols[i], i)
 		}
 		if err != nil {
-			return nil, err
+			errs = append(errs, err.(*Error))
+			if !recover {
+				return nil, errs
+			}
+			err = nil
 		}
 	}
-	return tokens, nil
+	return tokens, errs
+}
+
+// appendToken appends t to tokens. If t could not be read, a literal with the
+// value NaN is appended in its place.
+func appendToken(tokens []Token, t Token, err error) []Token {
+	if err != nil {
+		e := err.(*Error)
+		return append(tokens, token{typeLiteral, "NaN", span{e.Start, e.End}})
+	}
+	return append(tokens, t)
 }
 
 // readLiteral takes all symbols and the current position of the index. It then reads all
 // symbols that belong to the current literal and returns the last index of the literal,
-// a Token or an error.
+// a Token or an error. The value of the Token is the literal as it appears in the input
+// without digit separators, so it can be converted to other number types than float64
+// without loss of precision. Literals can use scientific notation (e.g. 2.5e-3), digit
+// separators (e.g. 1_000) and the suffix i for imaginary numbers (e.g. 2i).
 func readLiteral(symbols []rune, i int) (Token, int, error) {
+	if isBasePrefix(symbols, i) {
+		return readBaseLiteral(symbols, i)
+	}
 	start := i
-	for ; i < len(symbols) && isOfType(symbols[i], typeLiteral); i++ {
+	for ; i < len(symbols) && (isOfType(symbols[i], typeLiteral) || symbols[i] == '_'); i++ {
+	}
+	var err error
+	if isExponent(symbols, i) {
+		// the sign is only allowed right after the e, all other symbols are
+		// read to report malformed exponents like 1e2.5
+		for i += 2; i < len(symbols) && (isOfType(symbols[i], typeLiteral) || symbols[i] == '_'); i++ {
+		}
+	} else if i < len(symbols) && (symbols[i] == 'e' || symbols[i] == 'E') {
+		i++
+		if i < len(symbols) && (symbols[i] == '+' || symbols[i] == '-') {
+			i++
+		}
+		err = fmt.Errorf("the exponent of number %s has no digits", string(symbols[start:i]))
+	}
+	text := ""
+	if err == nil {
+		text, err = normalizeLiteral(string(symbols[start:i]))
+	}
+	if err == nil && i < len(symbols) && symbols[i] == 'i' &&
+		(i+1 == len(symbols) || !isOfType(symbols[i+1], typeIdentifier) && !isDigit(symbols[i+1])) {
+		text += "i"
+		i++
 	}
-	t, err := convertLiteral(symbols[start:i])
 	if err != nil {
-		return nil, i, err
+		e := wrapError(KindSyntax, span{start, i}, err).(*Error)
+		e.Token = string(symbols[start:i])
+		return nil, i - 1, e
 	}
 	// decrease value of i, outer for loop will increase it again
-	return t, i - 1, nil
+	return token{typeLiteral, text, span{start, i}}, i - 1, nil
 }
 
-// readLiteral takes all symbols and the current position of the index. It then reads all
-// symbols that belong to the current identifier and returns the last index of the literal,
-// a Token or an error.
+// bases maps the prefixes of integer literals to their base and name.
+var bases = map[rune]struct {
+	base int
+	name string
+}{
+	'x': {16, "hexadecimal"},
+	'o': {8, "octal"},
+	'b': {2, "binary"},
+}
+
+// isBasePrefix checks if a prefix for an integer literal in a different base than 10
+// starts at symbols[i], i.e. 0x, 0o or 0b.
+func isBasePrefix(symbols []rune, i int) bool {
+	if i+1 >= len(symbols) || symbols[i] != '0' {
+		return false
+	}
+	_, ok := bases[unicode.ToLower(symbols[i+1])]
+	return ok
+}
+
+// readBaseLiteral works like readLiteral, but reads an integer literal with one of the
+// prefixes in bases, e.g. 0x1F. The value of the Token is the literal in decimal
+// notation.
+func readBaseLiteral(symbols []rune, i int) (Token, int, error) {
+	start := i
+	b := bases[unicode.ToLower(symbols[i+1])]
+	// all letters and digits are read to report invalid digits like the g in 0x1g
+	for i += 2; i < len(symbols) && (unicode.IsLetter(symbols[i]) || isDigit(symbols[i]) || symbols[i] == '_'); i++ {
+	}
+	literal := string(symbols[start:i])
+	digits := []rune(literal[2:])
+	var err error
+	for j, r := range digits {
+		if r == '_' {
+			if j == 0 || j+1 == len(digits) || digits[j-1] == '_' || digits[j+1] == '_' {
+				err = fmt.Errorf("misplaced digit separator in number %s, '_' is only allowed between digits", literal)
+				break
+			}
+			continue
+		}
+		if d, ok := digitValue(r); !ok || d >= b.base {
+			err = fmt.Errorf("invalid digit '%c' in %s number %s", r, b.name, literal)
+			break
+		}
+	}
+	if err == nil && len(digits) == 0 {
+		err = fmt.Errorf("%s number %s has no digits", b.name, literal)
+	}
+	if err != nil {
+		e := wrapError(KindSyntax, span{start, i}, err).(*Error)
+		e.Token = literal
+		return nil, i - 1, e
+	}
+	n, _ := new(big.Int).SetString(strings.ReplaceAll(string(digits), "_", ""), b.base)
+	// decrease value of i, outer for loop will increase it again
+	return token{typeLiteral, n.String(), span{start, i}}, i - 1, nil
+}
+
+// digitValue returns the value of the digit r in bases up to 16 and whether r is
+// such a digit.
+func digitValue(r rune) (int, bool) {
+	switch {
+	case isDigit(r):
+		return int(r - '0'), true
+	case r >= 'a' && r <= 'f':
+		return int(r-'a') + 10, true
+	case r >= 'A' && r <= 'F':
+		return int(r-'A') + 10, true
+	default:
+		return 0, false
+	}
+}
+
+// isExponent checks if the exponent of a literal in scientific notation starts at
+// symbols[i]. The exponent consists of e or E followed by an optional sign and at
+// least one digit.
+func isExponent(symbols []rune, i int) bool {
+	if i >= len(symbols) || symbols[i] != 'e' && symbols[i] != 'E' {
+		return false
+	}
+	i++
+	if i < len(symbols) && (symbols[i] == '+' || symbols[i] == '-') {
+		i++
+	}
+	return i < len(symbols) && isDigit(symbols[i])
+}
+
+// normalizeLiteral checks that the literal s is well-formed and removes all digit
+// separators.
+func normalizeLiteral(s string) (string, error) {
+	mantissa, exponent := s, ""
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa, exponent = s[:i], s[i+1:]
+	}
+	if strings.Count(mantissa, ".") > 1 {
+		return "", fmt.Errorf("number %s contains more than one decimal point", s)
+	}
+	if strings.Contains(exponent, ".") {
+		return "", fmt.Errorf("the exponent of number %s has to be an integer", s)
+	}
+	if strings.Trim(mantissa, "._") == "" {
+		return "", fmt.Errorf("number %s contains no digits", s)
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		if r == '_' && (i == 0 || i+1 == len(runes) || !isDigit(runes[i-1]) || !isDigit(runes[i+1])) {
+			return "", fmt.Errorf("misplaced digit separator in number %s, '_' is only allowed between digits", s)
+		}
+	}
+	return strings.ReplaceAll(s, "_", ""), nil
+}
+
+// readIdentifier takes all symbols and the current position of the index. It then reads all
+// symbols that belong to the current identifier and returns the last index of the identifier
+// and a Token. Identifiers start with a letter which can be followed by letters and digits.
 func readIdentifier(symbols []rune, i int) (Token, int) {
 	start := i
-	for ; i < len(symbols) && isOfType(symbols[i], typeIdentifier); i++ {
+	for ; i < len(symbols) && (isOfType(symbols[i], typeIdentifier) || isDigit(symbols[i])); i++ {
 	}
 	// decrease value of i, outer for loop will increase it again
-	return token{typeIdentifier, string(symbols[start:i])}, i - 1
+	return token{typeIdentifier, string(symbols[start:i]), span{start, i}}, i - 1
 }
 
-// unknownSymbol generates an error message for some symbols that are not supported but known.
+// readUnit reads the symbol of a unit that contains uppercase letters, e.g. kWh,
+// starting at symbols[i] and returns the last index of the symbol and a Token of
+// typeIdentifier. All other identifiers consist of lowercase letters and digits,
+// so if the letters do not form the symbol of a unit, false is returned and the
+// uppercase letters are reported as unknown characters.
+func readUnit(symbols []rune, i int) (Token, int, bool) {
+	start := i
+	upper := false
+	for ; i < len(symbols) && (isOfType(symbols[i], typeIdentifier) || isUpper(symbols[i])); i++ {
+		upper = upper || isUpper(symbols[i])
+	}
+	symbol := string(symbols[start:i])
+	if !upper || !isUnit(symbol) {
+		return nil, start, false
+	}
+	// decrease value of i, outer for loop will increase it again
+	return token{typeIdentifier, symbol, span{start, i}}, i - 1, true
+}
+
+// readReference takes all symbols and the current position of the index. It then reads the
+// index following the '$' and returns the last index of the reference, a Token or an error.
+func readReference(symbols []rune, i int) (Token, int, error) {
+	start := i
+	for i++; i < len(symbols) && isDigit(symbols[i]); i++ {
+	}
+	if start+1 == i {
+		e := newError(KindSyntax, span{start, i}, "expected index of a previous result after '$'")
+		e.Token = "$"
+		return nil, i - 1, e
+	}
+	n, err := strconv.Atoi(string(symbols[start+1 : i]))
+	if err != nil {
+		e := wrapError(KindReference, span{start, i}, err).(*Error)
+		e.Token = string(symbols[start:i])
+		return nil, i - 1, e
+	}
+	// decrease value of i, outer for loop will increase it again
+	return token{typeReference, n, span{start, i}}, i - 1, nil
+}
+
+// unknownSymbol generates an error message for symbols that are not supported. For some
+// known symbols a hint is added.
 func unknownSymbol(symbol rune, position int) error {
-	errString := fmt.Sprintf("unknown character '%s' at position %d\n", string(symbol), position+1)
-	if symbol == '[' || symbol == ']' {
-		errString += "\tdid u want to use parentheses or braces?\n"
-	} else if symbol > 64 || symbol < 91 {
-		// A - Z
-		errString += "\tonly lowercase letters can be used as part of a macro identifier\n"
+	err := newError(KindSyntax, span{position, position + 1}, "unknown character '%s'", string(symbol))
+	err.Token = string(symbol)
+	if isUpper(symbol) {
+		err.Hint = "only lowercase letters can be used as part of an identifier"
 	}
-	return fmt.Errorf(errString)
+	return err
 }
 
-// convertLiteral takes a list of runes, parses it as a float64 and stores it in a Token
-func convertLiteral(symbols []rune) (Token, error) {
+// convertLiteral takes a list of runes and parses it as a float64. Literals are only
+// converted to float64 in types.ModeFloat, the other modes parse the text directly,
+// so numbers that are out of range for float64 are only rejected by this function.
+func convertLiteral(symbols []rune) (float64, error) {
 	v, err := strconv.ParseFloat(string(symbols), 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return math.NaN(), fmt.Errorf("number %s is out of range", string(symbols))
+	}
 	if err != nil {
-		return nil, err
+		return math.NaN(), fmt.Errorf("invalid number %s", string(symbols))
 	}
 	if math.IsNaN(v) || math.IsInf(v, 0) {
-		return nil, fmt.Errorf("unable to parse literal: the parsed value is not a valid number: '%f'", v)
+		return math.NaN(), fmt.Errorf("unable to parse literal: the parsed value is not a valid number: '%f'", v)
 	}
-	return token{typeLiteral, v}, nil
+	return v, nil
 }
 
 // isOfType is a convenience function to check if a symbol is a valid symbol for
@@ -124,6 +429,16 @@ func isOfType(symbol rune, t string) bool {
 	return runeSliceContains(validRunes[t], symbol)
 }
 
+// isUpper checks if a symbol is an uppercase letter.
+func isUpper(symbol rune) bool {
+	return symbol >= 'A' && symbol <= 'Z'
+}
+
+// isDigit checks if a symbol is a decimal digit.
+func isDigit(symbol rune) bool {
+	return symbol >= '0' && symbol <= '9'
+}
+
 // runeSliceContains checks if a runs slice contains a certain rune.
 func runeSliceContains(s []rune, r rune) bool {
 	for _, sr := range s {
@@ -0,0 +1,397 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// ErrLength is wrapped by errors that occur if lists of different lengths are
+// combined element-wise, e.g. [1, 2] + [1, 2, 3].
+var ErrLength = errors.New("lists of different lengths cannot be combined")
+
+// list is a list literal, e.g. [1, 2, x]. It evaluates to a types.List.
+type list struct {
+	elements []types.Node
+	span
+}
+
+func (l *list) Eval(env *types.Env) (float64, error) {
+	return evalFloat(l, env)
+}
+
+// EvalValue evaluates all elements of the list.
+func (l *list) EvalValue(env *types.Env) (types.Value, error) {
+	values := make(types.List, len(l.elements))
+	for i, e := range l.elements {
+		v, err := e.EvalValue(env)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// index selects a single element of a list, e.g. a[0]. Indices start at 0,
+// negative indices count from the end of the list, i.e. a[-1] is the last element.
+type index struct {
+	list  types.Node
+	index types.Node
+	span
+}
+
+func (x *index) Eval(env *types.Env) (float64, error) {
+	return evalFloat(x, env)
+}
+
+// EvalValue evaluates the list and the index and returns the selected element.
+func (x *index) EvalValue(env *types.Env) (types.Value, error) {
+	v, err := x.list.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := v.(types.List)
+	if !ok {
+		return nil, newError(KindEval, spanOf(x.list), "%v is not a list and cannot be indexed", v)
+	}
+	i, err := x.index.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := toInt(i)
+	if !ok || !n.IsInt64() {
+		return nil, wrapError(KindNotInteger, spanOf(x.index), fmt.Errorf("index %v is %w", i, ErrNotInteger))
+	}
+	k := n.Int64()
+	if k < 0 {
+		k += int64(len(l))
+	}
+	if k < 0 || k >= int64(len(l)) {
+		return nil, newError(KindEval, x.span, "index %v is out of range for a list of length %d", i, len(l))
+	}
+	return l[k], nil
+}
+
+// parseList parses a list literal, starting at the opening bracket. Rows separated
+// by semicolons, e.g. [1, 2; 3, 4], create a list of lists that is a matrix, all
+// rows have to have the same length.
+func parseList(p *parser) (types.Node, error) {
+	opening := p.peek()
+	if opening.Value().(rune) != '[' {
+		err := newError(KindSyntax, spanOf(opening), "unexpected closing bracket")
+		err.Token = "]"
+		return p.skip(err)
+	}
+	p.i++
+	p.closing = append(p.closing, ']')
+	defer func() { p.closing = p.closing[:len(p.closing)-1] }()
+	var rows []types.Node
+	var elements []types.Node
+	for !p.accept(typeBracket, ']') {
+		if len(elements) > 0 && p.accept(typeSemicolon, ';') {
+			rows = append(rows, newRow(elements))
+			elements = nil
+			continue
+		}
+		if len(elements) > 0 && !p.accept(typeComma, ',') {
+			if p.done() {
+				err := newError(KindSyntax, spanOf(opening), "missing closing bracket for opening bracket")
+				err.Token = "["
+				if _, err := p.fail(err); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if _, err := p.fail(p.unexpected()); err != nil {
+				return nil, err
+			}
+			p.sync()
+			if !p.accept(typeComma, ',') {
+				if !p.done() && p.peek().Value() == ';' {
+					continue
+				}
+				if p.done() || p.peek().Value() != ']' {
+					// the token belongs to an enclosing parenthesis or macro
+					err := newError(KindSyntax, spanOf(opening), "missing closing bracket for opening bracket")
+					err.Token = "["
+					p.record(err)
+					break
+				}
+				continue
+			}
+		}
+		e, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, e)
+	}
+	s := spanOf(opening).join(spanOf(p.tokens[p.i-1]))
+	if rows == nil {
+		return &list{elements, s}, nil
+	}
+	if len(elements) == 0 {
+		return p.fail(newError(KindSyntax, spanOf(p.tokens[p.i-1]), "missing row after ';'"))
+	}
+	rows = append(rows, newRow(elements))
+	for i, r := range rows {
+		if n, m := len(r.(*list).elements), len(rows[0].(*list).elements); n != m {
+			err := newError(KindShape, spanOf(r), "row %d of the matrix has %d element(s) but row 1 has %d", i+1, n, m)
+			err.Err = ErrShape
+			return p.fail(err)
+		}
+	}
+	return &list{rows, s}, nil
+}
+
+// newRow creates the list for a row of a matrix literal.
+func newRow(elements []types.Node) types.Node {
+	return &list{elements, spanOf(elements[0]).join(spanOf(elements[len(elements)-1]))}
+}
+
+// parseIndex parses the index of n, starting at the opening bracket.
+func (p *parser) parseIndex(n types.Node) (types.Node, error) {
+	opening := p.next()
+	p.closing = append(p.closing, ']')
+	defer func() { p.closing = p.closing[:len(p.closing)-1] }()
+	i, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(typeBracket, ']') {
+		if p.done() {
+			err := newError(KindSyntax, spanOf(opening), "missing closing bracket for opening bracket")
+			err.Token = "["
+			return p.fail(err)
+		}
+		if _, err := p.fail(p.unexpected()); err != nil {
+			return nil, err
+		}
+		p.sync()
+		p.accept(typeBracket, ']')
+	}
+	return &index{n, i, spanOf(n).join(spanOf(p.tokens[p.i-1]))}, nil
+}
+
+// broadcast calls f with args. If any of args is a list, f is called for each
+// element instead and the results are returned as list. Values that are not lists
+// are passed to every call, e.g. [1, 2] + 1 is [1+1, 2+1]. All lists have to have
+// the same length. Nested lists are handled recursively.
+func broadcast(args []types.Value, f func(args []types.Value) (types.Value, error)) (types.Value, error) {
+	n := -1
+	for _, a := range args {
+		l, ok := a.(types.List)
+		if !ok {
+			continue
+		}
+		if n >= 0 && len(l) != n {
+			return nil, fmt.Errorf("%w: the lengths are %d and %d", ErrLength, n, len(l))
+		}
+		n = len(l)
+	}
+	if n < 0 {
+		return f(args)
+	}
+	res := make(types.List, n)
+	for i := range res {
+		elements := make([]types.Value, len(args))
+		for j, a := range args {
+			if l, ok := a.(types.List); ok {
+				elements[j] = l[i]
+			} else {
+				elements[j] = a
+			}
+		}
+		v, err := broadcast(elements, f)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = v
+	}
+	return res, nil
+}
+
+// flatten replaces all lists in values by their elements, e.g. [1, [2, 3]], 4
+// becomes 1, 2, 3, 4.
+func flatten(values []types.Value) []types.Value {
+	var res []types.Value
+	for _, v := range values {
+		if l, ok := v.(types.List); ok {
+			res = append(res, flatten(l)...)
+		} else {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// scalar returns an error if v is a list. It is used where lists cannot be
+// handled element-wise, e.g. for conditions.
+func scalar(v types.Value) error {
+	if _, ok := v.(types.List); ok {
+		return fmt.Errorf("%v is a list but a number is expected", v)
+	}
+	return nil
+}
+
+// listFunction is a macro that evaluates all of its parameters and passes the
+// results to f. Unlike function, which is applied to each element of a list, f
+// receives lists as they are. It is used for the macros that work on whole lists.
+type listFunction struct {
+	f          func(env *types.Env, args []types.Value) (types.Value, error)
+	parameters []types.Node
+}
+
+// newListFunction creates a types.NewMacro for a listFunction that accepts at least
+// minArgs and at most maxArgs parameters, see newFunction.
+func newListFunction(name string, minArgs, maxArgs int, f func(env *types.Env, args []types.Value) (types.Value, error)) types.NewMacro {
+	return func(parameters []types.Node) (types.Macro, error) {
+		if len(parameters) < minArgs || (maxArgs >= 0 && len(parameters) > maxArgs) {
+			return nil, fmt.Errorf("%s: expected %s but got %d argument(s)",
+				name, expectedArgs(minArgs, maxArgs), len(parameters))
+		}
+		return &listFunction{f, parameters}, nil
+	}
+}
+
+func (fn *listFunction) Eval(env *types.Env) (float64, error) {
+	return evalFloat(fn, env)
+}
+
+// EvalValue evaluates the parameters and calls f.
+func (fn *listFunction) EvalValue(env *types.Env) (types.Value, error) {
+	args := make([]types.Value, len(fn.parameters))
+	for i, p := range fn.parameters {
+		v, err := p.EvalValue(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return fn.f(env, args)
+}
+
+// length implements len{list}.
+func length(env *types.Env, args []types.Value) (types.Value, error) {
+	l, ok := args[0].(types.List)
+	if !ok {
+		return nil, fmt.Errorf("len: %v is not a list", args[0])
+	}
+	return fromInt(env, big.NewInt(int64(len(l)))), nil
+}
+
+// mean implements mean{x, ...}, the arithmetic mean of all arguments. Lists are
+// replaced by their elements.
+func mean(env *types.Env, args []types.Value) (types.Value, error) {
+	values := flatten(args)
+	if len(values) == 0 {
+		return nil, fmt.Errorf("mean: the arguments do not contain any values")
+	}
+	sum := values[0]
+	var err error
+	for _, v := range values[1:] {
+		if sum, err = calcValue(env, '+', sum, v); err != nil {
+			return nil, err
+		}
+	}
+	return calcValue(env, '/', sum, fromInt(env, big.NewInt(int64(len(values)))))
+}
+
+// dot implements dot{a, b}, the dot product of two lists of the same length.
+func dot(env *types.Env, args []types.Value) (types.Value, error) {
+	a, ok := args[0].(types.List)
+	b, ok2 := args[1].(types.List)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("dot: the arguments have to be lists")
+	}
+	products, err := calcValue(env, '*', a, b)
+	if err != nil {
+		return nil, fmt.Errorf("dot: %w", err)
+	}
+	// the sum starts with the first product, so it keeps the unit of quantities
+	values := products.(types.List)
+	if len(values) == 0 {
+		return fromInt(env, big.NewInt(0)), nil
+	}
+	sum := values[0]
+	for _, v := range values[1:] {
+		if sum, err = calcValue(env, '+', sum, v); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// rangeList implements range{from, to}, the list of the values from, from+1, ...
+// up to to, see forRange.
+func rangeList(env *types.Env, args []types.Value) (types.Value, error) {
+	for _, a := range args {
+		if err := scalar(a); err != nil {
+			return nil, fmt.Errorf("range: %w", err)
+		}
+	}
+	values := types.List{}
+	err := forRange(env, "range", args[0], args[1], func(k types.Value) error {
+		values = append(values, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// mapping implements map{f, list}, which calls the anonymous function f for each
+// element of list and returns the results as list.
+type mapping struct {
+	f    types.Lambda
+	list types.Node
+}
+
+// newMapping implements types.NewMacro for map.
+func newMapping(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) != 2 {
+		return nil, fmt.Errorf("map: expected %s but got %d argument(s)", expectedArgs(2, 2), len(parameters))
+	}
+	f, err := lambdaParameter("map", parameters, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &mapping{f, parameters[1]}, nil
+}
+
+func (m *mapping) Eval(env *types.Env) (float64, error) {
+	return evalFloat(m, env)
+}
+
+// EvalValue evaluates the list and calls the function for each element.
+func (m *mapping) EvalValue(env *types.Env) (types.Value, error) {
+	l, err := evalList(env, "map", m.list)
+	if err != nil {
+		return nil, err
+	}
+	res := make(types.List, len(l))
+	for i, v := range l {
+		if res[i], err = m.f.Call(env, []types.Value{v}); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// evalList evaluates n, which has to result in a list. name is the macro n is a
+// parameter of, it is used in errors.
+func evalList(env *types.Env, name string, n types.Node) (types.List, error) {
+	v, err := n.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := v.(types.List)
+	if !ok {
+		return nil, fmt.Errorf("%s: %v is not a list", name, v)
+	}
+	return l, nil
+}
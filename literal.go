@@ -1,13 +1,42 @@
 package calc
 
+import (
+	"math"
+
+	"github.com/maxmoehl/calc/types"
+)
+
 type literal struct {
 	value float64
+	// text is the literal as it appears in the input, it is used to convert the
+	// literal without loss of precision in modes other than types.ModeFloat. It
+	// is empty for literals that have not been read from the input.
+	text string
+	// result is the previous result a reference has been replaced with, it is
+	// used instead of text to keep the result exact.
+	result types.Value
+	span
 }
 
-func (l *literal) Locked() bool {
-	return true
+func (l *literal) Eval(env *types.Env) (float64, error) {
+	return evalFloat(l, env)
 }
 
-func (l *literal) Eval() (float64, error) {
-	return l.value, nil
+func (l *literal) EvalValue(env *types.Env) (types.Value, error) {
+	if env.Mode() == types.ModeFloat && !math.IsNaN(l.value) && isNumber(l.result) {
+		return types.Float(l.value), nil
+	}
+	var v types.Value
+	var err error
+	if l.result != nil {
+		v, err = convertValue(env, l.result)
+	} else if l.text != "" {
+		v, err = parseValue(env, l.text)
+	} else {
+		v, err = convertValue(env, types.Float(l.value))
+	}
+	if err != nil {
+		return nil, wrapError(KindEval, l.span, err)
+	}
+	return v, nil
 }
@@ -0,0 +1,47 @@
+package calc
+
+import (
+	"github.com/maxmoehl/calc/types"
+)
+
+// logicalOperation is the logical and or or of two operands. The right operand is
+// only evaluated if the left one does not determine the result already, e.g. in
+// `0 and x` x is never evaluated.
+type logicalOperation struct {
+	// operator is either '∧' for and or '∨' for or
+	operator rune
+	// left contains the left operand
+	left types.Node
+	// right contains the right operand
+	right types.Node
+	span
+}
+
+func (o *logicalOperation) Eval(env *types.Env) (float64, error) {
+	return evalFloat(o, env)
+}
+
+// EvalValue returns 1 if the operation is true and 0 otherwise.
+func (o *logicalOperation) EvalValue(env *types.Env) (types.Value, error) {
+	l, err := o.left.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := truthy(l)
+	if err != nil {
+		return nil, wrapError(KindEval, spanOf(o.left), err)
+	}
+	if lb == (o.operator == '∨') {
+		// true or x is true, false and x is false
+		return boolValue(env, lb), nil
+	}
+	r, err := o.right.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := truthy(r)
+	if err != nil {
+		return nil, wrapError(KindEval, spanOf(o.right), err)
+	}
+	return boolValue(env, rb), nil
+}
@@ -4,31 +4,56 @@ import (
 	"github.com/maxmoehl/calc/types"
 )
 
-// macroIndex maps the identifier of a macro to a function that can be used to create
-// a macro for that identifier.
-var macroIndex map[string]types.NewMacro
-
-// macro acts as a wrapper for the Macro interface. It is used to add the Locked
-// function to implement the Node interface which is needed in order to be part
-// of the abstract syntax tree generated by the parser.
+// macro acts as a wrapper for the Macro interface. It implements the Node
+// interface which is needed in order to be part of the abstract syntax tree
+// generated by the parser.
 type macro struct {
+	// name is the identifier the macro has been invoked with
+	name string
 	// m is the actual macro
 	m types.Macro
+	span
 }
 
-func (m *macro) Locked() bool {
-	return true
+// Eval evaluates the macro. Errors that are not of type Error yet are attributed
+// to the macro.
+func (m *macro) Eval(env *types.Env) (float64, error) {
+	return evalFloat(m, env)
 }
 
-func (m *macro) Eval() (float64, error) {
-	return m.m.Eval()
+// EvalValue evaluates the macro using types.ValueMacro if it is implemented.
+// Otherwise the macro is evaluated using float64 and the result is converted to
+// the Mode of env.
+func (m *macro) EvalValue(env *types.Env) (types.Value, error) {
+	var v types.Value
+	var err error
+	if vm, ok := m.m.(types.ValueMacro); ok {
+		v, err = vm.EvalValue(env)
+		if err == nil {
+			// make sure the result matches the mode, even if the macro does not
+			// support it
+			v, err = convertValue(env, v)
+		}
+	} else {
+		var f float64
+		f, err = m.m.Eval(env)
+		if err == nil {
+			v, err = convertValue(env, types.Float(f))
+		}
+	}
+	if err == nil {
+		return v, nil
+	}
+	if _, ok := err.(*Error); ok {
+		return nil, err
+	}
+	e := wrapError(evalKind(err), m.span, err).(*Error)
+	e.Macro = m.name
+	return nil, e
 }
 
 // GetLoadedMacros is function to check which macros are enabled. It returns
 // a list of strings, each string being a valid identifier.
 func GetLoadedMacros() (macroIdentifier []string) {
-	for n := range macroIndex {
-		macroIdentifier = append(macroIdentifier, n)
-	}
-	return
-}
\ No newline at end of file
+	return defaultRegistry.Names()
+}
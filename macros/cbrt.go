@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// Cbrt calculates the cube root of its argument.
+type Cbrt struct {
+	value types.Node
+}
+
+func (c *Cbrt) Eval(env *types.Env) (float64, error) {
+	f, err := c.value.Eval(env)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return math.Cbrt(f), nil
+}
+
+// EvalValue implements types.ValueMacro, so the cube root is calculated with the
+// full precision in types.ModeBigFloat.
+func (c *Cbrt) EvalValue(env *types.Env) (types.Value, error) {
+	if env.Mode() != types.ModeBigFloat {
+		f, err := c.Eval(env)
+		return types.Float(f), err
+	}
+	v, err := c.value.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(types.BigFloat)
+	if !ok {
+		return nil, fmt.Errorf("expected a big.Float but got %v", v)
+	}
+	return types.NewBigFloat(cbrt(b.Big(), env.Precision())), nil
+}
+
+// cbrt calculates the cube root of a with prec bits using Newton's method. The
+// float64 approximation is used as starting point, every step doubles the number
+// of correct bits.
+func cbrt(a *big.Float, prec uint) *big.Float {
+	f, _ := a.Float64()
+	x := new(big.Float).SetPrec(prec + 32).SetFloat64(math.Cbrt(f))
+	if x.Sign() == 0 || x.IsInf() {
+		return x.SetPrec(prec)
+	}
+	three := big.NewFloat(3)
+	t := new(big.Float).SetPrec(prec + 32)
+	for bits := uint(26); bits < prec+32; bits *= 2 {
+		// x = (2x + a/x²) / 3
+		t.Mul(x, x)
+		t.Quo(a, t)
+		x.Add(x, x)
+		x.Add(x, t)
+		x.Quo(x, three)
+	}
+	return x.SetPrec(prec)
+}
+
+var NewCbrt = types.NewMacro(newCbrt)
+
+func newCbrt(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) != 1 {
+		return nil, fmt.Errorf("expected one argument but got %d arguments", len(parameters))
+	}
+	return &Cbrt{
+		value: parameters[0],
+	}, nil
+}
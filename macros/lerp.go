@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// Lerp interpolates linearly between a and b, lerp{a, b, 0} is a and lerp{a, b, 1}
+// is b.
+type Lerp struct {
+	a, b, t types.Node
+}
+
+func (l *Lerp) Eval(env *types.Env) (float64, error) {
+	a, err := l.a.Eval(env)
+	if err != nil {
+		return math.NaN(), err
+	}
+	b, err := l.b.Eval(env)
+	if err != nil {
+		return math.NaN(), err
+	}
+	t, err := l.t.Eval(env)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return a + (b-a)*t, nil
+}
+
+var NewLerp = types.NewMacro(newLerp)
+
+func newLerp(parameters []types.Node) (types.Macro, error) {
+	if len(parameters) != 3 {
+		return nil, fmt.Errorf("expected three arguments but got %d argument(s)", len(parameters))
+	}
+	return &Lerp{
+		a: parameters[0],
+		b: parameters[1],
+		t: parameters[2],
+	}, nil
+}
@@ -0,0 +1,400 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// ErrShape is wrapped by errors that occur if the shape of a matrix does not fit an
+// operation, e.g. if the number of columns of the left operand of @ does not match
+// the number of rows of the right one.
+var ErrShape = errors.New("the shapes do not match")
+
+// maxIdentity is the largest size of a matrix created by identity.
+const maxIdentity = 1000
+
+// matrix is a matrix in row-major order. Matrices are represented as a types.List
+// of rows, where each row is a types.List of numbers and all rows have the same
+// length, e.g. [[1, 2], [3, 4]].
+type matrix [][]types.Value
+
+// toMatrix converts v to a matrix. It fails if v is not a list of rows of the same
+// length that only contain numbers.
+func toMatrix(v types.Value) (matrix, error) {
+	rows, ok := v.(types.List)
+	if !ok || len(rows) == 0 {
+		return nil, fmt.Errorf("%v is not a matrix", v)
+	}
+	m := make(matrix, len(rows))
+	for i, r := range rows {
+		row, ok := r.(types.List)
+		if !ok || len(row) == 0 {
+			return nil, fmt.Errorf("%v is not a matrix, row %d is not a list of numbers", v, i+1)
+		}
+		if len(row) != len(rows[0].(types.List)) {
+			return nil, fmt.Errorf("%v is not a matrix, row %d has %d element(s) but row 1 has %d: %w",
+				v, i+1, len(row), len(m[0]), ErrShape)
+		}
+		for _, e := range row {
+			if err := scalar(e); err != nil {
+				return nil, fmt.Errorf("%v is not a matrix, row %d is not a list of numbers", v, i+1)
+			}
+		}
+		m[i] = row
+	}
+	return m, nil
+}
+
+// toVector checks if v is a list of numbers.
+func toVector(v types.Value) (types.List, bool) {
+	l, ok := v.(types.List)
+	if !ok || len(l) == 0 {
+		return nil, false
+	}
+	for _, e := range l {
+		if scalar(e) != nil {
+			return nil, false
+		}
+	}
+	return l, true
+}
+
+// rows returns the number of rows of m.
+func (m matrix) rows() int {
+	return len(m)
+}
+
+// cols returns the number of columns of m.
+func (m matrix) cols() int {
+	return len(m[0])
+}
+
+// shape describes the shape of m, e.g. 2x3 for a matrix with 2 rows and 3
+// columns.
+func (m matrix) shape() string {
+	return fmt.Sprintf("%dx%d", m.rows(), m.cols())
+}
+
+// value returns m as types.List.
+func (m matrix) value() types.List {
+	rows := make(types.List, len(m))
+	for i, r := range m {
+		rows[i] = append(types.List{}, r...)
+	}
+	return rows
+}
+
+// clone returns a copy of m that can be modified.
+func (m matrix) clone() matrix {
+	c := make(matrix, len(m))
+	for i, r := range m {
+		c[i] = append([]types.Value{}, r...)
+	}
+	return c
+}
+
+// identity returns the n×n identity matrix in the number type of the Mode of env.
+func identity(env *types.Env, n int) matrix {
+	zero, one := fromInt(env, big.NewInt(0)), fromInt(env, big.NewInt(1))
+	m := make(matrix, n)
+	for i := range m {
+		m[i] = make([]types.Value, n)
+		for j := range m[i] {
+			m[i][j] = zero
+		}
+		m[i][i] = one
+	}
+	return m
+}
+
+// matmul multiplies a and b, which are matrices or vectors, i.e. lists of numbers.
+// A vector is used as row if it is the left operand and as column if it is the
+// right one, the result is a vector in both cases. Two vectors result in their
+// dot product.
+func matmul(env *types.Env, a, b types.Value) (types.Value, error) {
+	left, right := matmulOperand(a, true), matmulOperand(b, false)
+	if left == nil || right == nil {
+		return nil, fmt.Errorf("the operands of @ have to be matrices or lists of numbers but got %v and %v", a, b)
+	}
+	if left.cols() != right.rows() {
+		return nil, fmt.Errorf("cannot multiply %s by %s, the number of columns of the left operand has to match the number of rows of the right one: %w",
+			describe(a, left), describe(b, right), ErrShape)
+	}
+	res := make(matrix, left.rows())
+	for i := range res {
+		res[i] = make([]types.Value, right.cols())
+		for j := range res[i] {
+			sum := fromInt(env, big.NewInt(0))
+			for k := 0; k < left.cols(); k++ {
+				p, err := calcValue(env, '*', left[i][k], right[k][j])
+				if err != nil {
+					return nil, err
+				}
+				sum, err = calcValue(env, '+', sum, p)
+				if err != nil {
+					return nil, err
+				}
+			}
+			res[i][j] = sum
+		}
+	}
+	_, leftVector := toVector(a)
+	_, rightVector := toVector(b)
+	switch {
+	case leftVector && rightVector:
+		return res[0][0], nil
+	case leftVector:
+		return types.List(res[0]), nil
+	case rightVector:
+		return res.transpose().value()[0], nil
+	}
+	return res.value(), nil
+}
+
+// matmulOperand converts the operand v of matmul to a matrix. Vectors are
+// converted to a matrix with a single row if row is set and to a matrix with a
+// single column otherwise. If v is neither nil is returned.
+func matmulOperand(v types.Value, row bool) matrix {
+	if l, ok := toVector(v); ok {
+		if row {
+			return matrix{l}
+		}
+		return matrix{l}.transpose()
+	}
+	m, err := toMatrix(v)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// describe describes the shape of the operand v of matmul, m is v as matrix.
+func describe(v types.Value, m matrix) string {
+	if l, ok := toVector(v); ok {
+		return fmt.Sprintf("a list of length %d", len(l))
+	}
+	return "a " + m.shape() + " matrix"
+}
+
+// transpose returns the transpose of m.
+func (m matrix) transpose() matrix {
+	t := make(matrix, m.cols())
+	for j := range t {
+		t[j] = make([]types.Value, m.rows())
+		for i := range m {
+			t[j][i] = m[i][j]
+		}
+	}
+	return t
+}
+
+// magnitude returns the absolute value of v as float64. It is used to select the
+// pivot in gaussJordan.
+func magnitude(v types.Value) float64 {
+	if c, ok := v.(types.Complex); ok {
+		return cmplx.Abs(complex128(c))
+	}
+	return math.Abs(v.Float64())
+}
+
+// tolerance returns the magnitude below which the pivot of each column of a is
+// treated as zero. In modes with exact arithmetic it is zero, otherwise it absorbs
+// the rounding errors of float64, so nearly singular matrices are reported as
+// singular. The tolerance is relative to the largest entry of the column, so
+// columns with small entries, e.g. of [1, 0; 0, 1e-15], are not affected by the
+// other columns.
+func tolerance(env *types.Env, a matrix) []float64 {
+	tol := make([]float64, a.cols())
+	if env.Mode() != types.ModeFloat && env.Mode() != types.ModeComplex {
+		return tol
+	}
+	for _, r := range a {
+		for j, e := range r {
+			tol[j] = math.Max(tol[j], magnitude(e))
+		}
+	}
+	for j := range tol {
+		tol[j] *= float64(a.rows()) * 1e-14
+	}
+	return tol
+}
+
+// gaussJordan reduces the square matrix a to the identity matrix using Gauss-Jordan
+// elimination with partial pivoting and applies the same row operations to b, which
+// has to have as many rows as a. It returns the determinant of a and the reduced b,
+// which is the solution x of a@x = b. If a is singular the determinant is zero and
+// the returned matrix is nil. a and b are not modified.
+func gaussJordan(env *types.Env, a, b matrix) (types.Value, matrix, error) {
+	a, b = a.clone(), b.clone()
+	n := a.rows()
+	tol := tolerance(env, a)
+	det := fromInt(env, big.NewInt(1))
+	var err error
+	for c := 0; c < n; c++ {
+		pivot := c
+		for r := c + 1; r < n; r++ {
+			if magnitude(a[r][c]) > magnitude(a[pivot][c]) {
+				pivot = r
+			}
+		}
+		if isZero(a[pivot][c]) || magnitude(a[pivot][c]) <= tol[c] {
+			return fromInt(env, big.NewInt(0)), nil, nil
+		}
+		if pivot != c {
+			a[pivot], a[c] = a[c], a[pivot]
+			b[pivot], b[c] = b[c], b[pivot]
+			det = negate(det)
+		}
+		p := a[c][c]
+		if det, err = calcValue(env, '*', det, p); err != nil {
+			return nil, nil, err
+		}
+		for _, row := range []([]types.Value){a[c], b[c]} {
+			for j := range row {
+				if row[j], err = calcValue(env, '/', row[j], p); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		for r := 0; r < n; r++ {
+			f := a[r][c]
+			if r == c || isZero(f) {
+				continue
+			}
+			if err := subtractRow(env, a[r], a[c], f); err != nil {
+				return nil, nil, err
+			}
+			if err := subtractRow(env, b[r], b[c], f); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return det, b, nil
+}
+
+// subtractRow subtracts f times src from dst.
+func subtractRow(env *types.Env, dst, src []types.Value, f types.Value) error {
+	for j := range dst {
+		p, err := calcValue(env, '*', f, src[j])
+		if err != nil {
+			return err
+		}
+		if dst[j], err = calcValue(env, '-', dst[j], p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isZero checks if v is zero.
+func isZero(v types.Value) bool {
+	b, err := truthy(v)
+	return err == nil && !b
+}
+
+// square converts v to a square matrix. name is the macro v is an argument of, it
+// is used in errors.
+func square(name string, v types.Value) (matrix, error) {
+	m, err := toMatrix(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if m.rows() != m.cols() {
+		return nil, fmt.Errorf("%s: expected a square matrix but got a %s matrix: %w", name, m.shape(), ErrShape)
+	}
+	return m, nil
+}
+
+// matrixProduct implements matmul{a, b}, see matmul.
+func matrixProduct(env *types.Env, args []types.Value) (types.Value, error) {
+	v, err := matmul(env, args[0], args[1])
+	if err != nil {
+		return nil, fmt.Errorf("matmul: %w", err)
+	}
+	return v, nil
+}
+
+// transpose implements transpose{m}. A list of numbers is transposed to a matrix
+// with a single column.
+func transpose(env *types.Env, args []types.Value) (types.Value, error) {
+	if l, ok := toVector(args[0]); ok {
+		return matrix{l}.transpose().value(), nil
+	}
+	m, err := toMatrix(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("transpose: %w", err)
+	}
+	return m.transpose().value(), nil
+}
+
+// determinant implements det{m}.
+func determinant(env *types.Env, args []types.Value) (types.Value, error) {
+	m, err := square("det", args[0])
+	if err != nil {
+		return nil, err
+	}
+	det, _, err := gaussJordan(env, m, make(matrix, m.rows()))
+	return det, err
+}
+
+// inverse implements inv{m}. Singular matrices are outside of the domain.
+func inverse(env *types.Env, args []types.Value) (types.Value, error) {
+	m, err := square("inv", args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, inv, err := gaussJordan(env, m, identity(env, m.rows()))
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, fmt.Errorf("inv: the singular matrix %v is %w", args[0], types.ErrDomain)
+	}
+	return inv.value(), nil
+}
+
+// solve implements solve{a, b}, which returns x with a@x = b. b can be a list of
+// numbers or a matrix, x has the same form. If a is singular there is no unique
+// solution and a is outside of the domain.
+func solve(env *types.Env, args []types.Value) (types.Value, error) {
+	a, err := square("solve", args[0])
+	if err != nil {
+		return nil, err
+	}
+	b := matmulOperand(args[1], false)
+	if b == nil {
+		return nil, fmt.Errorf("solve: %v is neither a matrix nor a list of numbers", args[1])
+	}
+	if b.rows() != a.rows() {
+		return nil, fmt.Errorf("solve: %v has %d rows but the %s matrix has %d: %w",
+			args[1], b.rows(), a.shape(), a.rows(), ErrShape)
+	}
+	_, x, err := gaussJordan(env, a, b)
+	if err != nil {
+		return nil, err
+	}
+	if x == nil {
+		return nil, fmt.Errorf("solve: the singular matrix %v is %w", args[0], types.ErrDomain)
+	}
+	if _, ok := toVector(args[1]); ok {
+		return x.transpose().value()[0], nil
+	}
+	return x.value(), nil
+}
+
+// identityMatrix implements identity{n}, the n×n identity matrix.
+func identityMatrix(env *types.Env, args []types.Value) (types.Value, error) {
+	n, ok := toInt(args[0])
+	if !ok {
+		return nil, fmt.Errorf("identity: the size %v is %w", args[0], ErrNotInteger)
+	}
+	if n.Sign() <= 0 || n.Cmp(big.NewInt(maxIdentity)) > 0 {
+		return nil, fmt.Errorf("identity: the size %v is %w, it has to be between 1 and %d", args[0], types.ErrDomain, maxIdentity)
+	}
+	return identity(env, int(n.Int64())).value(), nil
+}
@@ -11,39 +11,33 @@ import (
 type operation struct {
 	// operator contains the operation that should be carried out on the left and right operand
 	operator rune
-	// left contains either a value (float64) or a pointer to a Node
+	// left contains the left operand
 	left types.Node
-	// right contains either a value (float64) or a pointer to a Node
+	// right contains the right operand
 	right types.Node
-	// locked stores whether or not this operation can be modified
-	locked bool
+	span
 }
 
-func (o *operation) Locked() bool {
-	return o.locked
+// Eval evaluates an Operation by first evaluating all sub-operations and evaluating itself.
+func (o *operation) Eval(env *types.Env) (float64, error) {
+	return evalFloat(o, env)
 }
 
-// Eval evaluates an Operation by first evaluating all sub-operations and evaluating itself.
-func (o *operation) Eval() (float64, error) {
-	var l, r float64
-	var err error
-	if o.left == nil {
-		l = 0
-	} else {
-		l, err = o.left.Eval()
-	}
+// EvalValue works like Eval, but uses the number type selected by the Mode of env.
+func (o *operation) EvalValue(env *types.Env) (types.Value, error) {
+	l, err := o.left.EvalValue(env)
 	if err != nil {
-		return math.NaN(), err
+		return nil, err
 	}
-	if o.right == nil {
-		r = 0
-	} else {
-		r, err = o.right.Eval()
+	r, err := o.right.EvalValue(env)
+	if err != nil {
+		return nil, err
 	}
+	res, err := calcValue(env, o.operator, l, r)
 	if err != nil {
-		return math.NaN(), err
+		return nil, wrapError(evalKind(err), o.span, err)
 	}
-	return calc(o.operator, l, r)
+	return res, nil
 }
 
 // calc carries out a Operation, indicated by operator, on the two operands, left and right.
@@ -57,7 +51,29 @@ func calc(operator rune, left, right float64) (float64, error) {
 		return left * right, nil
 	case '/':
 		return left / right, nil
+	case '^':
+		return math.Pow(left, right), nil
+	case '÷':
+		if right == 0 {
+			return math.NaN(), errDivisionByZero
+		}
+		return math.Floor(left / right), nil
+	case '%':
+		if right == 0 {
+			return math.NaN(), errDivisionByZero
+		}
+		return mod(left, right), nil
 	default:
-		return math.NaN(), fmt.Errorf("unknown Operation: '%s'", string(operator))
+		return math.NaN(), fmt.Errorf("unknown Operation: '%s'", operatorString(operator))
+	}
+}
+
+// mod returns the remainder of the floored division left // right, which has the
+// same sign as right, e.g. -7 % 3 is 2.
+func mod(left, right float64) float64 {
+	m := math.Mod(left, right)
+	if m != 0 && (m < 0) != (right < 0) {
+		m += right
 	}
+	return m
 }
@@ -2,305 +2,717 @@ package calc
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 
 	"github.com/maxmoehl/calc/types"
 )
 
-type Parser func(root types.Node, tokens []Token, i int) (types.Node, int, error)
+// associativity defines how a sequence of operators with the same precedence is
+// grouped.
+type associativity int
 
-var parser map[string]Parser
+const (
+	// leftAssociative operators are grouped from left to right, e.g. 8/2/2 is
+	// evaluated as (8/2)/2.
+	leftAssociative associativity = iota
+	// rightAssociative operators are grouped from right to left, e.g. 2^3^2 is
+	// evaluated as 2^(3^2).
+	rightAssociative
+)
 
-func init() {
-	parser = make(map[string]Parser)
-	parser[typeOperator] = parseOperator
-	parser[typeLiteral] = parseLiteral
-	parser[typeParenthesis] = parseControl
-	parser[typeIdentifier] = parseMacro
-}
-
-// parse takes a list of tokens in the order they occur in the statement. It builds a abstract syntax tree
-// by chaining together operations in a recursive structure. Every Operation returned from parse is locked
-// because those operations are considered done and should not be modified.
-func parse(tokens []Token) (types.Node, error) {
-	var root types.Node
-	var err error
-
-	for i := 0; i < len(tokens); i++ {
-		root, i, err = parser[tokens[i].Type()](root, tokens, i)
-		if err != nil {
-			return nil, err
-		}
-	}
+// operatorInfo describes how the parser handles an operator.
+type operatorInfo struct {
+	// symbol is the operator as it is returned by the lexer
+	symbol rune
+	// arity is the number of operands, 1 for prefix operators and 2 for binary
+	// operators
+	arity int
+	// precedence is the binding strength of the operator, operators with a
+	// higher precedence bind tighter
+	precedence int
+	// associativity defines how operators of the same precedence are grouped
+	associativity associativity
+}
 
-	if op, ok := root.(*operation); ok {
-		_, err = getRightOperationNil(root)
-		if err == nil {
-			return nil, fmt.Errorf("expression has trailing operand")
+// operators contains all operators known to the parser. Adding a new operator only
+// requires an entry in this table and an implementation in calc.
+var operators = []operatorInfo{
+	{symbol: '∈', arity: 2, precedence: 0},
+	{symbol: '↦', arity: 2, precedence: 0},
+	{symbol: '?', arity: 2, precedence: 1, associativity: rightAssociative},
+	{symbol: '∨', arity: 2, precedence: 2},
+	{symbol: '∧', arity: 2, precedence: 3},
+	{symbol: '¬', arity: 1, precedence: 4},
+	{symbol: '≡', arity: 2, precedence: 5},
+	{symbol: '≠', arity: 2, precedence: 5},
+	{symbol: '<', arity: 2, precedence: 5},
+	{symbol: '≤', arity: 2, precedence: 5},
+	{symbol: '>', arity: 2, precedence: 5},
+	{symbol: '≥', arity: 2, precedence: 5},
+	{symbol: '|', arity: 2, precedence: 6},
+	{symbol: '⊕', arity: 2, precedence: 7},
+	{symbol: '&', arity: 2, precedence: 8},
+	{symbol: '≪', arity: 2, precedence: 9},
+	{symbol: '≫', arity: 2, precedence: 9},
+	{symbol: '+', arity: 2, precedence: 10},
+	{symbol: '-', arity: 2, precedence: 10},
+	{symbol: '*', arity: 2, precedence: 11},
+	{symbol: '/', arity: 2, precedence: 11},
+	{symbol: '÷', arity: 2, precedence: 11},
+	{symbol: '%', arity: 2, precedence: 11},
+	{symbol: '@', arity: 2, precedence: 11},
+	{symbol: '+', arity: 1, precedence: 12},
+	{symbol: '-', arity: 1, precedence: 12},
+	{symbol: '~', arity: 1, precedence: 12},
+	{symbol: '^', arity: 2, precedence: 13, associativity: rightAssociative},
+}
+
+// lookupOperator returns the operatorInfo for symbol with the given arity and
+// whether such an operator exists.
+func lookupOperator(symbol rune, arity int) (operatorInfo, bool) {
+	for _, op := range operators {
+		if op.symbol == symbol && op.arity == arity {
+			return op, true
 		}
-		op.locked = true
 	}
+	return operatorInfo{}, false
+}
 
-	return root, nil
+// operandParser is a function that parses an operand starting with a single type
+// of Token.
+type operandParser func(p *parser) (types.Node, error)
+
+var operandParsers map[string]operandParser
+
+func init() {
+	operandParsers = make(map[string]operandParser)
+	operandParsers[typeLiteral] = parseLiteral
+	operandParsers[typeParenthesis] = parseParenthesis
+	operandParsers[typeIdentifier] = parseIdentifier
+	operandParsers[typeBracket] = parseList
+	operandParsers[typeAssign] = parseAssign
 }
 
-// parseOperator handles tokens that are of typeOperator. The handling is
-// defined in parsePlusMinus and parseMulDiv
-func parseOperator(root types.Node, tokens []Token, i int) (types.Node, int, error) {
-	var err error
-	op := tokens[i].Value().(rune)
-	if op == '+' || op == '-' {
-		root, err = parsePlusMinus(root, op)
-		return root, i, err
-	} else if op == '*' || op == '/' {
-		root, err = parseMulDiv(root, op)
-		return root, i, err
-	}
-	return nil, i, fmt.Errorf("unknown Operation '%s' at position %d", string(op), i)
-}
-
-// parsePlusMinus parses the operators '+' and '-'. The tree gets shifted to the
-// left by replacing the root element with a new element and placing the root
-// element on the left side of the new element.
-// This leaves the right side of the root node empty for the next Node.
-// If this is the first Token of the expression, left will be nil since the
-// passed in root node is nil and the evaluation will assume it to be 0. This
-// allows for negative signs (and even unnecessary plus signs) at the
-// beginning of an expression.
-func parsePlusMinus(root types.Node, operator rune) (types.Node, error) {
-	// This covers a special case: If there is a root element and the root element
-	// is a operation, the right side needs to have a node. Otherwise we have two
-	// operators without a operand in between them.
-	if o, ok := root.(*operation); root != nil && ok && o.right == nil {
-		return nil, fmt.Errorf("expected right side of root node to be non-nil but got nil")
-	}
-	return &operation{
-		operator: operator,
-		left:     root,
-		right:    nil,
-	}, nil
+// parser is a precedence climbing parser. It reads the tokens from left to right and
+// builds the abstract syntax tree based on the precedence and associativity of the
+// operators found in operators.
+//
+// By default the parser stops at the first error. If recover is set, errors are
+// recorded in errs instead and the parser skips the offending tokens, resyncing at
+// the next `)`, `}` or `,` that belongs to an enclosing parenthesis or macro. The
+// abstract syntax tree built in this mode must not be evaluated.
+type parser struct {
+	// tokens contains all tokens of the statement
+	tokens []Token
+	// i is the index of the next token that has not been parsed
+	i int
+	// macros is used to look up macros
+	macros *Registry
+	// recover enables error recovery
+	recover bool
+	// errs contains all errors found in recovery mode
+	errs Errors
+	// closing contains the closing parenthesis or brace for each parenthesis and
+	// macro that is currently being parsed, the innermost one is stored at the end.
+	closing []rune
+	// function is the function whose body is being parsed, if any. Its parameters
+	// are in scope and it can be invoked before it has been defined.
+	function *userFunction
+	// locals contains the parameters of the anonymous functions whose bodies are
+	// being parsed, see parseLambda.
+	locals []string
 }
 
-// parseMulDiv parses the operators '*' and '/'. The lowest value on the right
-// that is Locked get shifted to a new position one Operation lower to the left.
-// The operator of the created Operation is given by the current Token. This
-// leaves the lowest right leave empty for the next Node. If the root element
-// is already locked create a new root node of type operation and place the
-// passed in root node on the left side of the newly created operation.
-func parseMulDiv(root types.Node, operator rune) (types.Node, error) {
-	if root == nil {
-		return nil, fmt.Errorf("error: expression cannot start with %s", string(operator))
-	}
-	if root.Locked() {
-		return &operation{
-			operator: operator,
-			left:     root,
-		}, nil
-	}
+// parseStatement parses a complete statement, see parser.parseStatement. It is the
+// entry point for the parser.
+func parseStatement(macros *Registry, tokens []Token) (types.Node, error) {
+	p := &parser{
+		tokens: tokens,
+		macros: macros,
+	}
+	return p.parseStatement()
+}
 
-	right, err := getRightOperationNonNil(root)
+// parseStatement parses a complete statement. A statement is either an assignment
+// of the form `identifier = expression` or a plain expression. If the statement is
+// empty nil is returned.
+func (p *parser) parseStatement() (types.Node, error) {
+	if len(p.tokens) == 0 {
+		return nil, nil
+	}
+	if assign := definitionAssign(p.tokens); assign > 0 {
+		err := newError(KindSyntax, spanOf(p.tokens[0]).join(spanOf(p.tokens[assign])),
+			"function definitions cannot be evaluated")
+		err.Hint = "define functions in interactive mode or in a file passed to -load"
+		return p.fail(err)
+	}
+	var name string
+	if len(p.tokens) >= 2 && p.tokens[0].Type() == typeIdentifier && p.tokens[1].Type() == typeAssign {
+		name = p.tokens[0].Value().(string)
+		p.i = 2
+		if !isVariableName(name) {
+			err := newError(KindSyntax, spanOf(p.tokens[0]), "cannot assign to unit '%s'", name)
+			err.Token = name
+			return p.fail(err)
+		}
+		if p.done() {
+			return p.fail(newError(KindSyntax, spanOf(p.tokens[1]),
+				"missing expression on the right side of the assignment to '%s'", name))
+		}
+		if _, ok := p.macros.Constant(name); ok {
+			err := newError(KindSyntax, spanOf(p.tokens[0]), "cannot assign to constant '%s'", name)
+			err.Token = name
+			err.Hint = "constants cannot be changed, use a different name for the variable"
+			if _, err := p.fail(err); err != nil {
+				return nil, err
+			}
+			// check the expression anyway
+			name = ""
+		}
+	}
+	root, err := p.parseExpression(0)
 	if err != nil {
 		return nil, err
 	}
-
-	right.right = &operation{
-		operator: operator,
-		left:     right.right,
-		right:    nil,
+	for !p.done() {
+		if _, err = p.fail(p.unexpected()); err != nil {
+			return nil, err
+		}
+		// skip the unexpected token and check the rest of the input as well
+		p.i++
+		if !p.done() {
+			if _, err = p.parseExpression(0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if name != "" {
+		return &assignment{
+			name:  name,
+			value: root,
+			span:  spanOf(p.tokens[0]).join(spanOf(root)),
+		}, nil
 	}
 	return root, nil
 }
 
-func parseLiteral(root types.Node, tokens []Token, i int) (types.Node, int, error) {
-	if root == nil {
-		return &literal{tokens[i].Value().(float64)}, i, nil
-	}
-	r, err := getRightOperationNil(root)
+// parseExpression parses operands and binary operators as long as the operators have
+// at least minPrecedence.
+func (p *parser) parseExpression(minPrecedence int) (types.Node, error) {
+	left, err := p.parsePrefix()
 	if err != nil {
-		return nil, i, err
+		return nil, err
 	}
-	r.right = &literal{tokens[i].Value().(float64)}
-	return root, i, nil
+	for !p.done() {
+		t := p.peek()
+		if t.Type() != typeOperator {
+			break
+		}
+		op, ok := lookupOperator(t.Value().(rune), 2)
+		if !ok {
+			return nil, p.unexpected()
+		}
+		if op.precedence < minPrecedence {
+			break
+		}
+		p.i++
+		if op.symbol == '?' {
+			left, err = p.parseConditional(left, op)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if op.symbol == '∈' || op.symbol == '↦' {
+			// the right side of in and to is a unit instead of an expression
+			left, err = p.parseConversion(left, t)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		next := op.precedence + 1
+		if op.associativity == rightAssociative {
+			next = op.precedence
+		}
+		right, err := p.parseExpression(next)
+		if err != nil {
+			return nil, err
+		}
+		s := spanOf(left).join(spanOf(right))
+		if op.symbol == '∧' || op.symbol == '∨' {
+			left = &logicalOperation{op.symbol, left, right, s}
+		} else {
+			left = &operation{
+				operator: op.symbol,
+				left:     left,
+				right:    right,
+				span:     s,
+			}
+		}
+	}
+	return left, nil
 }
 
-func parseControl(root types.Node, tokens []Token, i int) (types.Node, int, error) {
-	// store first value inside of parenthesis
-	startIndex := i + 1
-	// find corresponding closing parenthesis and check if one is found
-	i, err := getClosingPart(tokens, i)
-	if i == -1 {
-		return nil, i, fmt.Errorf("missing closing parenthesis for opening parenthesis at position %d", startIndex)
+// parseDefinition parses the function definition `name{parameters} = body` in
+// tokens, assign is the index of the '=', see definitionAssign.
+func parseDefinition(macros *Registry, tokens []Token, assign int) (*userFunction, error) {
+	f := &userFunction{name: tokens[0].Value().(string)}
+	parameters := tokens[2 : assign-1]
+	for i, t := range parameters {
+		if i%2 == 1 {
+			if t.Type() != typeComma {
+				return nil, newError(KindSyntax, spanOf(t), "expected ',' between parameters but got %s '%s'", t.Type(), tokenString(t))
+			}
+			continue
+		}
+		if t.Type() != typeIdentifier {
+			return nil, newError(KindSyntax, spanOf(t), "expected a parameter name but got %s '%s'", t.Type(), tokenString(t))
+		}
+		name := t.Value().(string)
+		if f.isParameter(name) {
+			err := newError(KindSyntax, spanOf(t), "duplicate parameter '%s'", name)
+			err.Token = name
+			return nil, err
+		}
+		f.parameters = append(f.parameters, name)
 	}
-	// build the Operation for whatever was inside the parenthesis
-	op, err := parse(tokens[startIndex:i])
-	if err != nil {
-		return nil, i, err
+	if len(parameters) > 0 && len(parameters)%2 == 0 {
+		last := parameters[len(parameters)-1]
+		return nil, newError(KindSyntax, spanOf(last), "expected a parameter name after ','")
 	}
-
-	if root == nil {
-		return op, i , nil
+	if assign+1 == len(tokens) {
+		return nil, newError(KindSyntax, spanOf(tokens[assign]), "missing body of function '%s'", f.name)
 	}
-
-	right, err := getRightOperationNil(root)
+	p := &parser{
+		tokens:   tokens[assign+1:],
+		macros:   macros,
+		function: f,
+	}
+	body, err := p.parseStatement()
 	if err != nil {
-		return nil, i, err
+		return nil, err
 	}
-	right.right = op
-	return root, i, nil
+	f.body = body
+	return f, nil
 }
 
-func parseMacro(root types.Node, tokens []Token, i int) (types.Node, int, error) {
-	id := tokens[i].Value().(string)
-	if macroIndex[id] == nil {
-		return nil, i, fmt.Errorf("unknown macro identifier %s", id)
-	}
-	i++
-	if tokens[i].Type() != typeBrace || tokens[i].Value().(rune) != '{' {
-		return nil, i, fmt.Errorf("expected opening brace after identifier but got type %s, value '%v'",
-			tokens[i].Type(), tokens[i].Value())
-	}
-	startIndex := i + 1
-	i, err := getClosingPart(tokens, i)
+// parseConditional parses the branches of the conditional expression
+// `condition ? then : otherwise`, starting after the question mark.
+func (p *parser) parseConditional(condition types.Node, op operatorInfo) (types.Node, error) {
+	then, err := p.parseExpression(0)
 	if err != nil {
-		return nil, i, err
+		return nil, err
 	}
-
-	parametersTokens, err := splitByComma(tokens[startIndex:i])
+	if !p.accept(typeColon, ':') {
+		var e *Error
+		if p.done() {
+			e = newError(KindSyntax, p.endOfInput(), "unexpected end of expression, expected ':'")
+		} else {
+			e = p.unexpected()
+			e.Hint = "a conditional expression has the form condition ? then : otherwise"
+		}
+		return p.fail(e)
+	}
+	otherwise, err := p.parseExpression(op.precedence)
 	if err != nil {
-		return nil, i, err
+		return nil, err
 	}
+	return &conditional{condition, then, otherwise, spanOf(condition).join(spanOf(otherwise))}, nil
+}
 
-	var parameters []types.Node
-	var op types.Node
-	for _, parameterTokens := range parametersTokens {
-		op, err = parse(parameterTokens)
-		if err != nil {
-			return nil, i, err
+// parsePrefix parses a single operand, which may be preceded by any number of prefix
+// operators. The operand of a prefix operator consists of all following operators
+// that bind at least as tight as the prefix operator itself, e.g. -2^2 is parsed
+// as -(2^2).
+func (p *parser) parsePrefix() (types.Node, error) {
+	if p.done() {
+		return p.fail(newError(KindSyntax, p.endOfInput(), "unexpected end of expression, expected an operand"))
+	}
+	t := p.peek()
+	if t.Type() != typeOperator {
+		return p.parseOperand()
+	}
+	op, ok := lookupOperator(t.Value().(rune), 1)
+	if !ok {
+		err := newError(KindSyntax, spanOf(t), "expected an operand but got operator '%s'", tokenString(t))
+		err.Token = tokenString(t)
+		if _, err := p.fail(err); err != nil {
+			return nil, err
 		}
-		parameters = append(parameters, op)
+		// skip the operator and try again, unless there is nothing left to parse
+		p.i++
+		if p.done() || p.isSyncPoint(p.peek()) {
+			return p.fail(err)
+		}
+		return p.parsePrefix()
 	}
-	m, err := macroIndex[id](parameters)
+	p.i++
+	operand, err := p.parseExpression(op.precedence)
 	if err != nil {
-		return nil, i, err
+		return nil, err
 	}
+	return &unaryOperation{
+		operator: op.symbol,
+		operand:  operand,
+		span:     spanOf(t).join(spanOf(operand)),
+	}, nil
+}
 
-	if root == nil {
-		return &macro{m}, i, nil
+// parseOperand parses a single operand using the operandParser registered for the
+// type of the next Token. The operand can be followed by any number of indices,
+// e.g. a[0][1]. Numbers and parentheses can be followed by a unit, e.g. 3 km or
+// (1/3) km.
+func (p *parser) parseOperand() (types.Node, error) {
+	t := p.peek()
+	f, ok := operandParsers[t.Type()]
+	if !ok {
+		return p.skip(p.unexpected())
+	}
+	n, err := f(p)
+	for err == nil && !p.done() && p.peek().Value() == '[' {
+		n, err = p.parseIndex(n)
+	}
+	if err == nil && (t.Type() == typeLiteral || t.Value() == '(') && p.isUnit(p.i) {
+		n, err = p.parseQuantity(n)
 	}
+	return n, err
+}
+
+// parseLiteral parses a literal that has been read from the input or a previous
+// result that replaces a reference.
+func parseLiteral(p *parser) (types.Node, error) {
+	t := p.next()
+	if v, ok := t.Value().(types.Value); ok {
+		return &literal{v.Float64(), "", v, spanOf(t)}, nil
+	}
+	return newLiteral(t.Value().(string), spanOf(t)), nil
+}
 
-	rightLeave, err := getRightOperationNil(root)
+// newLiteral creates a literal from text, which has already been validated by the
+// lexer or Registry.DefineConstant.
+func newLiteral(text string, s span) *literal {
+	if isImaginary(text) {
+		// imaginary literals cannot be represented as float64
+		return &literal{math.NaN(), text, nil, s}
+	}
+	v, err := strconv.ParseFloat(text, 64)
 	if err != nil {
-		return nil, i, err
-	}
-	rightLeave.right = &macro{m}
-	return root, i , nil
-}
-
-// getClosingPart tries to find the rune passed in as closing by ignoring all nested
-// section delimited by opening and closed parameters.
-func getClosingPart(tokens []Token, i int) (int, error) {
-	var opening, closing rune
-	t := tokens[i].Type()
-	if t == typeParenthesis || t == typeBrace {
-		opening = validRunes[t][0]
-		closing = validRunes[t][1]
-	} else {
-		return -1, fmt.Errorf("unknown control combination %s", t)
-	}
-	parentheses := 1
-	for i++; i < len(tokens); i++ {
-		if tokens[i].Type() == typeParenthesis || tokens[i].Type() == typeBrace {
-			if tokens[i].Value().(rune) == opening {
-				parentheses++
-			} else if tokens[i].Value().(rune) == closing {
-				parentheses--
-			}
-			if parentheses == 0 {
-				return i, nil
-			}
-		}
+		// the number is out of range for float64, but can still be used in other
+		// modes, the error is reported when it is evaluated in types.ModeFloat
+		v = math.NaN()
 	}
-	return -1, fmt.Errorf("missing at least one closing part of control %s", t)
+	return &literal{v, text, nil, s}
 }
 
-// getRightOperationNil searches the lowest operation on the right and expects that
-// operation is nil on the right side.
-func getRightOperationNil(n types.Node) (*operation, error) {
-	o, err := getRightOperation(n)
+// parseParenthesis parses an expression inside of parentheses. The span of the
+// returned node includes the parentheses, so errors point at the whole operand.
+func parseParenthesis(p *parser) (types.Node, error) {
+	opening := p.peek()
+	if opening.Value().(rune) != '(' {
+		err := newError(KindSyntax, spanOf(opening), "unexpected closing parenthesis")
+		err.Token = ")"
+		return p.skip(err)
+	}
+	p.i++
+	p.closing = append(p.closing, ')')
+	defer func() { p.closing = p.closing[:len(p.closing)-1] }()
+	op, err := p.parseExpression(0)
 	if err != nil {
 		return nil, err
 	}
-	if o.right != nil {
-		return nil, fmt.Errorf("lowest operation on the right side has no nil right side: %+v", o.right)
+	closed := p.accept(typeParenthesis, ')')
+	if !closed {
+		if p.done() {
+			err := newError(KindSyntax, spanOf(opening), "missing closing parenthesis for opening parenthesis")
+			err.Token = "("
+			return p.fail(err)
+		}
+		if _, err := p.fail(p.unexpected()); err != nil {
+			return nil, err
+		}
+		p.sync()
+		closed = p.accept(typeParenthesis, ')')
+	}
+	if e, ok := op.(extendable); ok && closed {
+		e.extend(spanOf(opening).join(spanOf(p.tokens[p.i-1])))
 	}
-	return o, nil
+	return op, nil
 }
 
-// getRightOperationNonNil is the corresponding function to getRightOperationNil. It
-// searches for the lowest operation on the right and expects the right side to be
-// nil. If that is not the case an error is returned
-func getRightOperationNonNil(n types.Node) (*operation, error) {
-	o, err := getRightOperation(n)
-	if err != nil {
+// parseAssign handles tokens of typeAssign. Assignments are only allowed at the start of
+// a statement and are handled by parseStatement, every other occurrence is an error.
+func parseAssign(p *parser) (types.Node, error) {
+	err := newError(KindSyntax, spanOf(p.peek()), "unexpected '='")
+	err.Token = "="
+	err.Hint = "assignments are only allowed at the start of an expression"
+	if _, err := p.fail(err); err != nil {
 		return nil, err
 	}
-	if o.right == nil {
-		return nil, fmt.Errorf("lowest operation on the right side is nil")
+	// skip the '=' and use the following operand instead
+	p.i++
+	return p.parsePrefix()
+}
+
+// parseIdentifier handles tokens of typeIdentifier. If the identifier is followed by
+// an opening brace it is parsed as a macro, otherwise it refers to a constant or, if
+// there is no constant with that name, to a variable.
+func parseIdentifier(p *parser) (types.Node, error) {
+	t := p.next()
+	id := t.Value().(string)
+	if !p.accept(typeBrace, '{') {
+		if isUnit(id) && !isVariableName(id) {
+			err := newError(KindSyntax, spanOf(t), "the unit %s has to follow a number", id)
+			err.Token = id
+			err.Hint = fmt.Sprintf("write 1 %s to use a single unit", id)
+			return p.fail(err)
+		}
+		if p.function.isParameter(id) || p.isLocal(id) {
+			return &variable{id, spanOf(t)}, nil
+		}
+		if text, ok := p.macros.Constant(id); ok {
+			return newLiteral(text, spanOf(t)), nil
+		}
+		return &variable{id, spanOf(t)}, nil
 	}
-	return o, nil
+	return parseMacro(p, t)
 }
 
-// getRightOperation returns the lowest operation by going down the right side of the
-// operations tree. It returns the operation where the right leaf is not another
-// unlocked operation (the right leaf is either a locked operation, a number or nil).
-// Before passing a node it should be checked if that node is locked. If a locked node
-// is passed in or the node is not of type operation, nil is returned.
-// We do not traverse into locked operations as they are considered a completed
-// unit and should not be modified, see parse for more details.
-func getRightOperation(n types.Node) (*operation, error) {
-	if n.Locked() {
-		return nil, fmt.Errorf("received locked Node but expected unlocked Node")
+// parseMacro parses the parameters of the macro identified by t, starting after the
+// opening brace, and creates the macro.
+func parseMacro(p *parser, t Token) (types.Node, error) {
+	id := t.Value().(string)
+	newMacro, ok := p.macros.Lookup(id)
+	if p.function != nil && id == p.function.name {
+		newMacro, ok = p.function.newMacro, true
 	}
-	o, ok := n.(*operation)
 	if !ok {
-		return nil, fmt.Errorf("expected Node of type operation but got %T", n)
-	}
-	if o.right == nil || o.right.Locked() {
-		return o, nil
-	} else {
-		return getRightOperation(o.right)
-	}
-}
-
-// splitByComma returns the passed in tokens as all tokens separated by commas.
-// It ignores commas that are inside another macro.
-func splitByComma(tokens []Token) ([][]Token, error) {
-	var t Token
-	var res [][]Token
-	var err error
-	currentStart := 0
-	for i := 0; i < len(tokens); i++ {
-		t = tokens[i]
-		if t.Type() == typeComma {
-			// if the Token is a comma, take all tokens that passed since last comma or start and
-			// store them in a slice.
-			res = append(res, tokens[currentStart:i])
-			currentStart = i + 1
-		}
-		if t.Type() == typeBrace {
-			// If we find a opening brace we skip to the end of it to avoid the commas that any
-			// nested macro might have.
-			if t.Value().(rune) == '}' {
-				return nil, fmt.Errorf("unexpected closing brace")
+		err := newError(KindUnknownMacro, spanOf(t), "unknown macro identifier %s", id)
+		err.Token = id
+		err.Macro = id
+		if _, err := p.fail(err); err != nil {
+			return nil, err
+		}
+		// the parameters are still checked in recovery mode
+	}
+	p.closing = append(p.closing, '}')
+	defer func() { p.closing = p.closing[:len(p.closing)-1] }()
+	errs := len(p.errs)
+	if variableMacros[id] && p.declaresVariable() {
+		locals := p.locals
+		p.locals = append(p.locals[:len(p.locals):len(p.locals)], p.peek().Value().(string))
+		defer func() { p.locals = locals }()
+	}
+	var parameters []types.Node
+	for !p.accept(typeBrace, '}') {
+		if len(parameters) > 0 && !p.accept(typeComma, ',') {
+			if p.done() {
+				err := newError(KindSyntax, spanOf(t).join(p.endOfInput()), "missing closing brace for macro %s", id)
+				err.Macro = id
+				if _, err := p.fail(err); err != nil {
+					return nil, err
+				}
+				break
 			}
-			i, err = getClosingPart(tokens, i)
-			if err != nil {
+			if _, err := p.fail(p.unexpected()); err != nil {
 				return nil, err
 			}
+			p.sync()
+			if !p.accept(typeComma, ',') {
+				if p.done() || p.peek().Value() != '}' {
+					// the token belongs to an enclosing parenthesis or macro
+					err := newError(KindSyntax, spanOf(t), "missing closing brace for macro %s", id)
+					err.Macro = id
+					p.record(err)
+					break
+				}
+				continue
+			}
 		}
-		if i == len(tokens) - 1 {
-			// if we are at the end of the section we group everything we have
-			res = append(res, tokens[currentStart:i + 1])
+		var op types.Node
+		var err error
+		if p.isLambda() {
+			// anonymous functions are only allowed as parameters of macros
+			op, err = p.parseLambda()
+		} else {
+			op, err = p.parseExpression(0)
 		}
+		if err != nil {
+			return nil, err
+		}
+		parameters = append(parameters, op)
+	}
+	s := spanOf(t).join(spanOf(p.tokens[p.i-1]))
+	if !ok || len(p.errs) > errs {
+		// the arguments are not checked if the parameters contain errors
+		return &literal{math.NaN(), "", nil, s}, nil
+	}
+	m, err := newMacro(parameters)
+	if err != nil {
+		e := newError(KindArguments, s, "%s", err.Error())
+		e.Macro = id
+		e.Err = err
+		return p.fail(e)
+	}
+	return &macro{id, m, s}, nil
+}
+
+// declaresVariable checks if the next parameter of a macro only consists of an
+// identifier, e.g. the k in sum{k, 1, 10, k^2}.
+func (p *parser) declaresVariable() bool {
+	return p.i+1 < len(p.tokens) && p.peek().Type() == typeIdentifier &&
+		p.tokens[p.i+1].Type() == typeComma
+}
+
+// fail handles an error that occurred while parsing. Without recovery err is
+// returned. In recovery mode err is recorded and a placeholder is returned in place
+// of the Node that could not be parsed.
+func (p *parser) fail(err *Error) (types.Node, error) {
+	if !p.recover {
+		return nil, err
+	}
+	p.record(err)
+	return &literal{math.NaN(), "", nil, span{err.Start, err.End}}, nil
+}
+
+// skip works like fail but also skips the next Token in recovery mode. Tokens that
+// can be handled by an enclosing parenthesis or macro are not skipped, instead the
+// enclosing parser resyncs at them.
+func (p *parser) skip(err *Error) (types.Node, error) {
+	n, e := p.fail(err)
+	if e != nil || p.done() || p.isSyncPoint(p.peek()) {
+		return n, e
+	}
+	p.i++
+	if t := p.tokens[p.i-1]; t.Type() == typeBrace && t.Value() == '{' {
+		// skip the content of the braces as well
+		p.sync()
+		p.accept(typeBrace, '}')
+	}
+	return n, e
+}
+
+// record adds err to the errors found in recovery mode. Since some errors are
+// detected by multiple parsers, an error is only recorded once per position.
+func (p *parser) record(err *Error) {
+	if n := len(p.errs); n > 0 && p.errs[n-1].Start == err.Start {
+		return
+	}
+	p.errs = append(p.errs, err)
+}
+
+// sync skips all tokens until the next Token that can be handled by an enclosing
+// parenthesis or macro. Nested parentheses and braces are skipped as a whole.
+func (p *parser) sync() {
+	depth := 0
+	for !p.done() {
+		t := p.peek()
+		if depth == 0 && p.isSyncPoint(t) {
+			return
+		}
+		if v := t.Value(); v == '(' || v == '{' || v == '[' {
+			depth++
+		} else if (v == ')' || v == '}' || v == ']') && depth > 0 {
+			depth--
+		}
+		p.i++
+	}
+}
+
+// isSyncPoint checks if t closes one of the enclosing parentheses, macros or lists,
+// or separates the parameters of an enclosing macro or the elements or rows of a
+// list.
+func (p *parser) isSyncPoint(t Token) bool {
+	v, ok := t.Value().(rune)
+	if !ok || (t.Type() != typeParenthesis && t.Type() != typeBrace && t.Type() != typeBracket &&
+		t.Type() != typeComma && t.Type() != typeSemicolon) {
+		return false
+	}
+	for _, c := range p.closing {
+		if c == v || (v == ',' && c != ')') || (v == ';' && c == ']') {
+			return true
+		}
+	}
+	return false
+}
+
+// done checks if all tokens have been parsed.
+func (p *parser) done() bool {
+	return p.i >= len(p.tokens)
+}
+
+// peek returns the next Token without consuming it.
+func (p *parser) peek() Token {
+	return p.tokens[p.i]
+}
+
+// next consumes and returns the next Token.
+func (p *parser) next() Token {
+	t := p.tokens[p.i]
+	p.i++
+	return t
+}
+
+// accept consumes the next Token if it is of type t and has the value v. It
+// returns whether the Token has been consumed.
+func (p *parser) accept(t string, v rune) bool {
+	if p.done() || p.peek().Type() != t || p.peek().Value() != v {
+		return false
+	}
+	p.i++
+	return true
+}
+
+// unexpected creates an error for the next Token, which cannot be handled at the
+// current position.
+func (p *parser) unexpected() *Error {
+	if p.done() {
+		return newError(KindSyntax, p.endOfInput(), "unexpected end of expression")
+	}
+	t := p.peek()
+	err := newError(KindSyntax, spanOf(t), "unexpected %s '%s'", t.Type(), tokenString(t))
+	err.Token = tokenString(t)
+	if t.Value() == '→' {
+		err.Hint = "anonymous functions can only be passed to macros, e.g. sum{k -> k^2, 1, 10}"
+	}
+	if t.Type() == typeIdentifier && p.i > 0 && p.tokens[p.i-1].Type() == typeLiteral {
+		if isUnit(tokenString(t)) {
+			// parameters and macros are not read as unit, see parser.isUnit
+			err.Hint = fmt.Sprintf("'%s' is not used as unit here, use * to multiply by it", tokenString(t))
+		} else {
+			err.Hint = fmt.Sprintf("'%s' is not a known unit", tokenString(t))
+		}
+	}
+	return err
+}
+
+// isVariableName checks if name can be used as name of a variable. Unlike the
+// symbols of units, the names of variables cannot contain uppercase letters.
+func isVariableName(name string) bool {
+	for _, r := range name {
+		if isUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// endOfInput returns an empty span right after the last Token.
+func (p *parser) endOfInput() span {
+	if len(p.tokens) == 0 {
+		return span{}
+	}
+	end := p.tokens[len(p.tokens)-1].End()
+	return span{end, end}
+}
+
+// tokenString returns the value of t as it appears in the input.
+func tokenString(t Token) string {
+	switch v := t.Value().(type) {
+	case rune:
+		return operatorString(v)
+	default:
+		return fmt.Sprintf("%v", v)
 	}
-	return res, nil
 }
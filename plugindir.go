@@ -0,0 +1,51 @@
+package calc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PluginDir returns the directory plugins should be loaded from. The directory can
+// be set using the environment variable CALC_PLUGIN_DIR. If the variable is not
+// present $HOME/.calc is used. If the variable is present but empty no plugins
+// should be loaded and the empty string is returned.
+func PluginDir() string {
+	if dir, found := os.LookupEnv("CALC_PLUGIN_DIR"); found {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".calc")
+}
+
+// PluginError describes why a single plugin could not be loaded.
+type PluginError struct {
+	// Path is the path of the plugin file
+	Path string
+	// Err is the reason the plugin could not be loaded
+	Err error
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("unable to load plugin %s: %s", e.Path, e.Err.Error())
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+// PluginErrors is returned by LoadPlugins if one or more plugins could not be
+// loaded. It contains one PluginError for every plugin that has been skipped.
+type PluginErrors []*PluginError
+
+func (e PluginErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
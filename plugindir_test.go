@@ -0,0 +1,31 @@
+package calc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPluginDir(t *testing.T) {
+	old, found := os.LookupEnv("CALC_PLUGIN_DIR")
+	defer func() {
+		if found {
+			os.Setenv("CALC_PLUGIN_DIR", old)
+		} else {
+			os.Unsetenv("CALC_PLUGIN_DIR")
+		}
+	}()
+
+	os.Setenv("CALC_PLUGIN_DIR", "/opt/calc")
+	if got := PluginDir(); got != "/opt/calc" {
+		t.Errorf("PluginDir() got = %v, want %v", got, "/opt/calc")
+	}
+	os.Setenv("CALC_PLUGIN_DIR", "")
+	if got := PluginDir(); got != "" {
+		t.Errorf("PluginDir() got = %v, want empty string", got)
+	}
+	os.Unsetenv("CALC_PLUGIN_DIR")
+	if got := PluginDir(); filepath.Base(got) != ".calc" {
+		t.Errorf("PluginDir() got = %v, want $HOME/.calc", got)
+	}
+}
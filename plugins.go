@@ -5,7 +5,6 @@ package calc
 import (
 	"fmt"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"plugin"
 	"strings"
@@ -13,30 +12,41 @@ import (
 	"github.com/maxmoehl/calc/types"
 )
 
-func init() {
-	pluginFiles, err := getMacroFiles()
-	if err != nil {
-		panic(err.Error())
-	}
-	plugins, err := loadPlugins(pluginFiles)
-	macroIndex = make(map[string]types.NewMacro)
+// LoadPlugins loads all plugins inside dir into the default Registry, see
+// Registry.LoadPlugins for details.
+func LoadPlugins(dir string) error {
+	return defaultRegistry.LoadPlugins(dir)
+}
+
+// LoadPlugins loads all plugins with the file ending `.so` inside dir and its
+// subdirectories and registers their macros in r. Plugins that cannot be loaded
+// are skipped, the remaining plugins are still loaded. In that case the returned
+// error is of type PluginErrors and contains one PluginError for every plugin
+// that has been skipped. If dir cannot be read the error is returned as is.
+func (r *Registry) LoadPlugins(dir string) error {
+	pluginFiles, err := getMacroFiles(dir)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
-	for _, p := range plugins {
-		err = loadMacros(p)
+	var errs PluginErrors
+	for _, pf := range pluginFiles {
+		err = loadPlugin(r, pf)
 		if err != nil {
-			panic(err.Error())
+			errs = append(errs, &PluginError{Path: pf, Err: err})
 		}
 	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-func getMacroFiles() ([]string, error) {
+// getMacroFiles returns the paths of all files inside dir that end with `.so`.
+func getMacroFiles(dir string) ([]string, error) {
 	var macroFiles []string
-	home := os.Getenv("HOME")
-	err := filepath.WalkDir(filepath.Join(home, ".calc"), func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
 		if d.IsDir() {
 			return nil
@@ -52,43 +62,54 @@ func getMacroFiles() ([]string, error) {
 	return macroFiles, nil
 }
 
-func loadPlugins(pluginFiles []string) ([]*plugin.Plugin, error) {
-	var plugins []*plugin.Plugin
-	var err error
-	var p *plugin.Plugin
-
-	for _, pf := range pluginFiles {
-		p, err = plugin.Open(pf)
+// loadPlugin opens the plugin at path and registers all of its macros in r. The
+// macros are only registered if all of them can be loaded.
+func loadPlugin(r *Registry, path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	macros, err := loadMacros(p)
+	if err != nil {
+		return err
+	}
+	for identifier, f := range macros {
+		err = r.Register(identifier, f)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		plugins = append(plugins, p)
 	}
-	return plugins, nil
+	return nil
 }
 
-func loadMacros(p *plugin.Plugin) error {
-	// load index to find macroIndex
+// loadMacros looks up the Index of the plugin and returns all macros listed in it.
+func loadMacros(p *plugin.Plugin) (map[string]types.NewMacro, error) {
+	// load index to find all macros
 	s, err := p.Lookup("Index")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	index, ok := s.(*types.Index)
 	if !ok {
-		return fmt.Errorf("index needs to be of type types.Index")
+		return nil, fmt.Errorf("index needs to be of type types.Index but is %T", s)
 	}
 
+	macros := make(map[string]types.NewMacro, len(*index))
 	var f *types.NewMacro
 	for identifier, functionName := range *index {
+		if !isIdentifier(identifier) {
+			return nil, fmt.Errorf("'%s' is not a valid identifier", identifier)
+		}
 		s, err = p.Lookup(functionName)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("macro '%s': %w", identifier, err)
 		}
 		f, ok = s.(*types.NewMacro)
 		if !ok {
-			return fmt.Errorf("function to create macroIndex must be of type types.NewMacro")
+			return nil, fmt.Errorf("macro '%s': function %s must be of type types.NewMacro but is %T",
+				identifier, functionName, s)
 		}
-		macroIndex[identifier] = *f
+		macros[identifier] = *f
 	}
-	return nil
+	return macros, nil
 }
@@ -0,0 +1,44 @@
+// +build linux,cgo darwin,cgo freebsd,cgo
+
+package calc
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry_LoadPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"broken.so", "ignored.txt"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("not a plugin"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewRegistry()
+	err = r.LoadPlugins(dir)
+	var errs PluginErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadPlugins() error = %v, want PluginErrors", err)
+	}
+	if len(errs) != 1 || errs[0].Path != filepath.Join(dir, "broken.so") {
+		t.Errorf("LoadPlugins() got errors for %v, want only broken.so", errs)
+	}
+	if _, err = r.NewSession().Eval("sqrt{4}"); err != nil {
+		t.Errorf("Session.Eval() error = %v, built-in macros should still be available", err)
+	}
+
+	err = r.LoadPlugins(filepath.Join(dir, "missing"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadPlugins() error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
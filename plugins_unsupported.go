@@ -0,0 +1,25 @@
+// +build !linux,!darwin,!freebsd !cgo
+
+package calc
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// LoadPlugins is not supported on this platform and always returns an error.
+func LoadPlugins(dir string) error {
+	return defaultRegistry.LoadPlugins(dir)
+}
+
+// LoadPlugins is not supported on this platform and always returns an error. Like
+// on supported platforms, the error is returned as is if dir cannot be read, so a
+// missing directory can be detected using fs.ErrNotExist.
+func (r *Registry) LoadPlugins(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	return fmt.Errorf("unable to load plugins from %s: plugins are not supported on %s/%s or without cgo",
+		dir, runtime.GOOS, runtime.GOARCH)
+}
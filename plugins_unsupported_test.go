@@ -0,0 +1,29 @@
+// +build !linux,!darwin,!freebsd !cgo
+
+package calc
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry_LoadPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRegistry()
+	if err = r.LoadPlugins(dir); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadPlugins() error = %v, want error that plugins are not supported", err)
+	}
+	err = r.LoadPlugins(filepath.Join(dir, "missing"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadPlugins() error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
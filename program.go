@@ -0,0 +1,69 @@
+package calc
+
+import (
+	"math"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// Program is a compiled expression. Compiling an expression once and evaluating
+// the resulting Program avoids running the lexer and parser for every evaluation,
+// which is useful if the same expression is evaluated many times with different
+// variables.
+//
+// A Program is never modified after it has been compiled, so it is safe to call
+// Eval from multiple goroutines at the same time as long as every goroutine uses
+// its own Env.
+type Program struct {
+	// root is the root node of the abstract syntax tree, nil for an empty
+	// expression.
+	root types.Node
+	// input is the expression the Program has been compiled from, it is used
+	// to point out the location of errors.
+	input string
+}
+
+// Compile runs the lexer and parser on input and returns the resulting Program.
+// Since there are no previous results, references like $0 cannot be used.
+// Macros are looked up in the default Registry, see RegisterMacro.
+func Compile(input string) (*Program, error) {
+	return compile(input, nil, defaultRegistry)
+}
+
+// Eval evaluates the Program. All variables are looked up in env, assignments
+// store their result in env. If env is nil an empty Env is used. If any errors
+// occur math.NaN and the error are returned. Results that are lists or quantities
+// cannot be returned as float64, use EvalValue for them.
+func (p *Program) Eval(env *types.Env) (float64, error) {
+	v, err := p.EvalValue(env)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return p.float(v)
+}
+
+// float converts the result v of p to float64, see toFloat. If v cannot be
+// converted, the error is attributed to the whole expression.
+func (p *Program) float(v types.Value) (float64, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return math.NaN(), withInput(wrapError(KindEval, spanOf(p.root), err), p.input)
+	}
+	return f, nil
+}
+
+// EvalValue works like Eval but returns the result in the Mode of env, see
+// types.Env.SetMode.
+func (p *Program) EvalValue(env *types.Env) (types.Value, error) {
+	if env == nil {
+		env = types.NewEnv()
+	}
+	if p.root == nil {
+		return convertValue(env, types.Float(0))
+	}
+	res, err := p.root.EvalValue(env)
+	if err != nil {
+		return nil, withInput(err, p.input)
+	}
+	return res, nil
+}
@@ -0,0 +1,156 @@
+package calc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// defaultRegistry is used by all package level functions like Eval and Compile.
+// Plugins register their macros in this Registry.
+var defaultRegistry = NewRegistry()
+
+// Registry stores the macros and constants that can be used in an expression. Each
+// macro is identified by its name and created using the associated types.NewMacro
+// function. Constants are resolved when an expression is compiled, see
+// Registry.DefineConstant. Functions defined using Registry.Define are stored as
+// macros as well. A Registry is safe for concurrent use.
+//
+// Besides the default Registry, which is used by the package level functions,
+// applications can create their own Registry to control exactly which macros
+// are available.
+type Registry struct {
+	mu        sync.RWMutex
+	macros    map[string]types.NewMacro
+	constants map[string]string
+	// functions contains the functions defined in this Registry, each of them is
+	// also stored in macros
+	functions map[string]*userFunction
+	// parent is used to look up macros and constants that are not stored in this
+	// Registry, it is nil for registries created by NewRegistry
+	parent *Registry
+}
+
+// NewRegistry creates a Registry that contains all built-in macros, like sqrt,
+// sin or log, and the built-in constants pi, e, tau and phi.
+func NewRegistry() *Registry {
+	r := &Registry{
+		macros:    make(map[string]types.NewMacro, len(builtins)),
+		constants: make(map[string]string, len(builtinConstants)),
+	}
+	for name, f := range builtins {
+		r.macros[name] = f
+	}
+	for name, v := range builtinConstants {
+		r.constants[name] = v
+	}
+	return r
+}
+
+// child creates an empty Registry that uses r for all macros and constants it does
+// not contain itself. It allows sessions to define functions without affecting
+// other sessions.
+func (r *Registry) child() *Registry {
+	return &Registry{
+		macros:    make(map[string]types.NewMacro),
+		constants: make(map[string]string),
+		parent:    r,
+	}
+}
+
+// RegisterMacro registers a macro in the default Registry, which is used by Eval,
+// Compile and NewSession. See Registry.Register for details.
+func RegisterMacro(name string, f types.NewMacro) error {
+	return defaultRegistry.Register(name, f)
+}
+
+// Register makes the macro available under name. The name has to be a valid
+// identifier, i.e. start with a lowercase letter followed by lowercase letters
+// or digits. If a macro with the same name already exists it is replaced, this
+// also applies to built-in macros.
+func (r *Registry) Register(name string, f types.NewMacro) error {
+	if f == nil {
+		return fmt.Errorf("unable to register macro '%s': function is nil", name)
+	}
+	if !isIdentifier(name) {
+		return fmt.Errorf("unable to register macro '%s': name must be a valid identifier", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.macros[name] = f
+	delete(r.functions, name)
+	return nil
+}
+
+// Lookup returns the function to create the macro name and whether the macro
+// exists.
+func (r *Registry) Lookup(name string) (types.NewMacro, bool) {
+	r.mu.RLock()
+	f, ok := r.macros[name]
+	r.mu.RUnlock()
+	if !ok && r.parent != nil {
+		return r.parent.Lookup(name)
+	}
+	return f, ok
+}
+
+// Names returns the names of all registered macros in alphabetical order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.macros))
+	for n := range r.macros {
+		names = append(names, n)
+	}
+	r.mu.RUnlock()
+	if r.parent != nil {
+		names = append(names, r.parent.Names()...)
+	}
+	return uniqueSorted(names)
+}
+
+// Compile works like the package level Compile, but looks up macros in r.
+func (r *Registry) Compile(input string) (*Program, error) {
+	return compile(input, nil, r)
+}
+
+// Check works like the package level Check, but looks up macros in r.
+func (r *Registry) Check(input string) Errors {
+	return check(input, nil, r)
+}
+
+// NewSession works like the package level NewSession, but looks up macros in r.
+// Functions defined in the session are not added to r.
+func (r *Registry) NewSession() *Session {
+	return &Session{
+		env:    types.NewEnv(),
+		macros: r.child(),
+	}
+}
+
+// uniqueSorted sorts names alphabetically and removes all duplicates.
+func uniqueSorted(names []string) []string {
+	sort.Strings(names)
+	res := names[:0]
+	for i, n := range names {
+		if i == 0 || n != names[i-1] {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// isIdentifier checks if s is a valid identifier. Names of operators, like xor, are
+// not valid identifiers.
+func isIdentifier(s string) bool {
+	if _, ok := keywordOperators[s]; ok {
+		return false
+	}
+	for i, r := range s {
+		if !isOfType(r, typeIdentifier) && (i == 0 || !isDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,184 @@
+package calc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// Session evaluates multiple expressions after one another and keeps track of
+// their results. Previous results can be referenced in later expressions: $0
+// is the most recent result, $1 the one before that and so on. Variables that
+// are assigned in one expression can be used in all following expressions.
+type Session struct {
+	// history contains all results of this session, the most recent one
+	// is stored at the end.
+	history []types.Value
+	// env contains all variables of this session
+	env *types.Env
+	// macros contains all macros that can be used in this session, including the
+	// functions defined in this session
+	macros *Registry
+}
+
+// NewSession creates a Session without any previous results or variables. Macros
+// are looked up in the default Registry, see RegisterMacro.
+func NewSession() *Session {
+	return defaultRegistry.NewSession()
+}
+
+// Eval evaluates input in the same way as the package level Eval, but allows
+// input to reference previous results. If the evaluation succeeds the result is
+// added to the history of the session. Results that are lists or quantities are
+// added to the history as well, but they cannot be returned as float64, use
+// EvalValue for them.
+func (s *Session) Eval(input string) (float64, error) {
+	p, v, err := s.eval(input)
+	if err != nil {
+		return math.NaN(), err
+	}
+	return p.float(v)
+}
+
+// EvalValue works like Eval but returns the result in the Mode of the Env of the
+// session, see Env and types.Env.SetMode. References to previous results keep the
+// precision of the results.
+func (s *Session) EvalValue(input string) (types.Value, error) {
+	_, v, err := s.eval(input)
+	return v, err
+}
+
+// eval compiles and evaluates input and adds the result to the history. The
+// compiled Program is returned as well.
+func (s *Session) eval(input string) (*Program, types.Value, error) {
+	p, err := compile(input, s.values(), s.macros)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := p.EvalValue(s.env)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.history = append(s.history, res)
+	return p, res, nil
+}
+
+// History returns all previous results of the session. The first element is
+// the most recent result, i.e. the result referenced by $0.
+func (s *Session) History() []float64 {
+	history := make([]float64, len(s.history))
+	for i, v := range s.values() {
+		history[i] = v.Float64()
+	}
+	return history
+}
+
+// HistoryValues works like History but returns the results without converting them
+// to float64.
+func (s *Session) HistoryValues() []types.Value {
+	return s.values()
+}
+
+// values returns all previous results of the session like History, but without
+// converting them to float64.
+func (s *Session) values() []types.Value {
+	values := make([]types.Value, len(s.history))
+	for i, v := range s.history {
+		values[len(s.history)-1-i] = v
+	}
+	return values
+}
+
+// Env returns the Env that stores the variables of the session. It can be used
+// to define or inspect variables outside of expressions.
+func (s *Session) Env() *types.Env {
+	return s.env
+}
+
+// Check works like the package level Check, but allows input to reference previous
+// results of the session.
+func (s *Session) Check(input string) Errors {
+	return check(input, s.values(), s.macros)
+}
+
+// Define defines a function that can be used in all following expressions of the
+// session, see Registry.Define. The function is not available in other sessions.
+func (s *Session) Define(definition string) error {
+	return s.macros.Define(definition)
+}
+
+// Undefine removes a function that has been defined using Define. Functions
+// defined in the Registry of the session, e.g. using calc.Define, are listed by
+// Functions but cannot be removed.
+func (s *Session) Undefine(name string) error {
+	return s.macros.Undefine(name)
+}
+
+// Functions returns the definitions of all functions that can be used in the
+// session, ordered by their names.
+func (s *Session) Functions() []string {
+	return s.macros.Functions()
+}
+
+// Load reads r line by line and evaluates each line in the session, e.g. to load
+// function definitions from a file. Function definitions are passed to Define, all
+// other lines are evaluated like in EvalValue, but their results are not added to
+// the history. Empty lines and lines starting with # are skipped. Load stops at
+// the first line that cannot be evaluated and returns its error.
+func (s *Session) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var err error
+		if IsDefinition(line) {
+			err = s.Define(line)
+		} else {
+			err = s.load(line)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// load evaluates input like EvalValue without adding the result to the history.
+func (s *Session) load(input string) error {
+	p, err := compile(input, s.values(), s.macros)
+	if err != nil {
+		return err
+	}
+	_, err = p.EvalValue(s.env)
+	return err
+}
+
+// resolveReferences replaces all tokens of typeReference with a literal containing
+// the value they reference in history. References that cannot be resolved are
+// replaced by a literal with the value NaN and an error is returned for each of
+// them.
+func resolveReferences(tokens []Token, history []types.Value) ([]Token, Errors) {
+	var errs Errors
+	for i, t := range tokens {
+		if t.Type() != typeReference {
+			continue
+		}
+		n := t.Value().(int)
+		if n >= len(history) {
+			e := newError(KindReference, spanOf(t), "unable to resolve $%d: only %d previous result(s) available",
+				n, len(history))
+			e.Token = fmt.Sprintf("$%d", n)
+			errs = append(errs, e)
+			tokens[i] = token{typeLiteral, "NaN", spanOf(t)}
+			continue
+		}
+		tokens[i] = token{typeLiteral, history[n], spanOf(t)}
+	}
+	return tokens, errs
+}
@@ -5,11 +5,16 @@ package calc
 type Token interface {
 	Type() string
 	Value() interface{}
+	// Start returns the index of the first rune of the Token in the input.
+	Start() int
+	// End returns the index after the last rune of the Token in the input.
+	End() int
 }
 
 type token struct {
 	tokenType string
 	value     interface{}
+	span
 }
 
 func (t token) Type() string {
@@ -19,3 +24,64 @@ func (t token) Type() string {
 func (t token) Value() interface{} {
 	return t.value
 }
+
+// span describes which part of the input a Token or Node has been created from.
+// Both indices count runes, not bytes.
+type span struct {
+	// start is the index of the first rune
+	start int
+	// end is the index after the last rune
+	end int
+}
+
+func (s span) Start() int {
+	return s.start
+}
+
+func (s span) End() int {
+	return s.end
+}
+
+// positioned is implemented by all Tokens and all Nodes created by the parser.
+type positioned interface {
+	Start() int
+	End() int
+}
+
+// spanOf returns the span of n. If n does not implement positioned, e.g. because
+// it has not been created by the parser, the zero span is returned.
+func spanOf(n interface{}) span {
+	if p, ok := n.(positioned); ok {
+		return span{p.Start(), p.End()}
+	}
+	return span{}
+}
+
+// Locked implements types.Node for all Nodes that embed span. Nodes are never
+// modified after they have been parsed, so it always returns true.
+func (s span) Locked() bool {
+	return true
+}
+
+// extendable is implemented by all Nodes that embed span, it allows the parser to
+// extend the span of a Node after it has been created, e.g. by the parentheses
+// around it.
+type extendable interface {
+	extend(other span)
+}
+
+// extend changes s to cover other as well.
+func (s *span) extend(other span) {
+	*s = s.join(other)
+}
+
+// join returns a span that covers s and other.
+func (s span) join(other span) span {
+	if other.start < s.start {
+		s.start = other.start
+	}
+	if other.end > s.end {
+		s.end = other.end
+	}
+	return s
+}
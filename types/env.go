@@ -0,0 +1,160 @@
+package types
+
+import "sort"
+
+// Env stores the variables that are available while an expression is evaluated
+// and the Mode the expression is evaluated in. The zero value is an empty Env
+// that is ready to use. An Env must not be modified by multiple goroutines at the
+// same time.
+//
+// A nil *Env behaves like an empty Env for all methods that only read it, e.g.
+// Get, Names and Mode, and Delete does nothing. Set, SetValue, SetMode and
+// SetPrecision require a non-nil Env, use NewEnv to create one.
+//
+// An Env can have nested scopes, see NewScope and NewCallScope. Variables of a
+// scope shadow the variables of the Env it has been created from.
+type Env struct {
+	vars map[string]Value
+	mode Mode
+	prec uint
+	// parent is the Env the scope has been created from, it is nil for the
+	// global Env
+	parent *Env
+	// depth is the number of scopes between the global Env and this one
+	depth int
+}
+
+// NewEnv creates an Env without any variables.
+func NewEnv() *Env {
+	return &Env{}
+}
+
+// Get returns the value of the variable name and whether that variable exists.
+func (e *Env) Get(name string) (float64, bool) {
+	v, ok := e.GetValue(name)
+	if !ok {
+		return 0, false
+	}
+	return v.Float64(), true
+}
+
+// GetValue works like Get but returns the Value as it has been stored. Variables
+// that are not defined in a scope are looked up in the Env it has been created
+// from.
+func (e *Env) GetValue(name string) (Value, bool) {
+	if e == nil {
+		return nil, false
+	}
+	v, ok := e.vars[name]
+	if !ok && e.parent != nil {
+		return e.parent.GetValue(name)
+	}
+	return v, ok
+}
+
+// Set binds value to the variable name. If the variable already exists its
+// value is replaced. In a scope the variable is only defined in the scope. e must
+// not be nil.
+func (e *Env) Set(name string, value float64) {
+	e.SetValue(name, Float(value))
+}
+
+// SetValue works like Set but accepts any Value. e must not be nil.
+func (e *Env) SetValue(name string, value Value) {
+	if e.vars == nil {
+		e.vars = make(map[string]Value)
+	}
+	e.vars[name] = value
+}
+
+// Delete removes the variable name, if it does not exist nothing happens. In a
+// scope only variables of the scope can be deleted.
+func (e *Env) Delete(name string) {
+	if e == nil {
+		return
+	}
+	delete(e.vars, name)
+}
+
+// Names returns the names of all variables in alphabetical order. In a scope
+// only the variables of the scope are returned.
+func (e *Env) Names() []string {
+	if e == nil {
+		return nil
+	}
+	names := make([]string, 0, len(e.vars))
+	for n := range e.vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Mode returns the Mode expressions are evaluated in, ModeFloat by default.
+func (e *Env) Mode() Mode {
+	if e == nil {
+		return ModeFloat
+	}
+	return e.mode
+}
+
+// SetMode changes the Mode expressions are evaluated in. Variables keep the
+// Value they have been assigned. e must not be nil.
+func (e *Env) SetMode(mode Mode) {
+	e.mode = mode
+}
+
+// Precision returns the precision in bits used by ModeBigFloat. If no precision
+// has been set DefaultPrecision is returned.
+func (e *Env) Precision() uint {
+	if e == nil || e.prec == 0 {
+		return DefaultPrecision
+	}
+	return e.prec
+}
+
+// SetPrecision sets the precision in bits used by ModeBigFloat. A precision of 0
+// restores DefaultPrecision. e must not be nil.
+func (e *Env) SetPrecision(prec uint) {
+	e.prec = prec
+}
+
+// NewScope creates a nested scope for local variables, e.g. the counter of a
+// loop. All variables of e are visible in the scope unless they are shadowed by a
+// variable of the scope, assignments only affect the scope. The scope uses the
+// Mode and precision of e.
+func (e *Env) NewScope() *Env {
+	return &Env{
+		mode:   e.Mode(),
+		prec:   e.Precision(),
+		parent: e,
+		depth:  e.Depth() + 1,
+	}
+}
+
+// NewCallScope works like NewScope, but only the variables of the global Env are
+// visible in the scope, not those of the scopes e belongs to. It is used for the
+// parameters of functions, so a function cannot access the variables of its
+// caller.
+func (e *Env) NewCallScope() *Env {
+	s := e.Global().NewScope()
+	s.mode, s.prec, s.depth = e.Mode(), e.Precision(), e.Depth()+1
+	return s
+}
+
+// Global returns the Env all scopes of e have been created from. It is e itself
+// if e is not a scope.
+func (e *Env) Global() *Env {
+	for e != nil && e.parent != nil {
+		e = e.parent
+	}
+	return e
+}
+
+// Depth returns the number of nested scopes e is in, 0 for the global Env.
+func (e *Env) Depth() int {
+	if e == nil {
+		return 0
+	}
+	return e.depth
+}
@@ -1,20 +1,65 @@
 package types
 
+import "errors"
+
+// ErrDomain should be returned by macros if they are evaluated with arguments that
+// are outside of their domain, e.g. the square root of a negative number. It can
+// also be wrapped to provide more details.
+var ErrDomain = errors.New("outside of the domain")
+
 // Node is the basic building block that the parser uses to build the abstract
 // syntax tree.
 type Node interface {
 	// Locked indicates whether or not it is possible to modify this Node.
-	// If false the parser will not try to modify this value. This currently
-	// only returns false for the internal operation in certain cases.
+	//
+	// Deprecated: The parser does not modify Nodes anymore, all Nodes created
+	// by the parser return true.
 	Locked() bool
 	// Eval returns the value this Node resolves to, or an error if one occurs.
-	Eval() (float64, error)
+	// Any variables are looked up in env.
+	Eval(env *Env) (float64, error)
+	// EvalValue works like Eval but returns the value in the Mode of env, see
+	// Env.SetMode.
+	EvalValue(env *Env) (Value, error)
+}
+
+// Variable is implemented by Nodes that refer to a variable. Macros can use it to
+// get the name of a parameter that declares a variable, e.g. k in
+// sum{k, 1, 10, k^2}, and evaluate other parameters with that variable bound to
+// different values, see EvalWith.
+type Variable interface {
+	Node
+	// Name returns the name of the variable.
+	Name() string
+}
+
+// Lambda is implemented by Nodes that are anonymous functions, e.g. x -> x^2 or
+// (a, b) -> a*b. Anonymous functions cannot be evaluated on their own, they can
+// only be passed to macros, which invoke them using Call.
+type Lambda interface {
+	Node
+	// Parameters returns the names of the parameters of the function.
+	Parameters() []string
+	// Call evaluates the body of the function with its parameters bound to args.
+	// env has to be the Env the macro has been evaluated in, it provides the
+	// variables the function can access besides its parameters.
+	Call(env *Env, args []Value) (Value, error)
+}
+
+// EvalWith evaluates node in a new scope of env in which the variable name is
+// bound to value, see Env.NewScope. Macros can use it to evaluate a parameter
+// multiple times with different values of a variable.
+func EvalWith(env *Env, node Node, name string, value Value) (Value, error) {
+	scope := env.NewScope()
+	scope.SetValue(name, value)
+	return node.EvalValue(scope)
 }
 
 // Macro is the interface all macros have to implement.
 type Macro interface {
 	// Eval returns the value this macro resolves to, or an error if one occurs.
-	Eval() (float64, error)
+	// env has to be passed on when evaluating the parameters of the macro.
+	Eval(env *Env) (float64, error)
 }
 
 // NewMacro is a function a plugin needs to provide for every macro it contains.
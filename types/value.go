@@ -0,0 +1,246 @@
+package types
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// Mode selects the number type that is used to evaluate an expression, see
+// Env.SetMode.
+type Mode int
+
+const (
+	// ModeFloat evaluates expressions using float64. It is the default mode.
+	ModeFloat Mode = iota
+	// ModeBigFloat evaluates expressions using big.Float with the precision of the
+	// Env, which avoids most rounding errors of float64, e.g. 0.1+0.2 is 0.3.
+	ModeBigFloat
+	// ModeRational evaluates expressions using big.Rat. Literals and the basic
+	// arithmetic operators are exact, e.g. 1/3*3 is 1.
+	ModeRational
+	// ModeComplex evaluates expressions using complex128, e.g. sqrt{-1} is 1i.
+	// Imaginary literals like 2i can only be used in this mode.
+	ModeComplex
+)
+
+// DefaultPrecision is the precision in bits that is used by ModeBigFloat if no
+// precision has been set.
+const DefaultPrecision uint = 256
+
+// Value is the result of evaluating a Node. Its concrete type depends on the Mode
+// of the Env the Node has been evaluated with.
+type Value interface {
+	// Float64 returns the float64 nearest to the Value.
+	Float64() float64
+	// String formats the Value in decimal notation.
+	String() string
+}
+
+// Float is the Value used by ModeFloat.
+type Float float64
+
+func (f Float) Float64() float64 {
+	return float64(f)
+}
+
+func (f Float) String() string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 64)
+}
+
+// BigFloat is the Value used by ModeBigFloat.
+type BigFloat struct {
+	f *big.Float
+}
+
+// NewBigFloat creates a BigFloat from f. f must not be modified afterwards.
+func NewBigFloat(f *big.Float) BigFloat {
+	return BigFloat{f}
+}
+
+// Big returns the value as big.Float. The returned value must not be modified.
+func (b BigFloat) Big() *big.Float {
+	return b.f
+}
+
+func (b BigFloat) Float64() float64 {
+	f, _ := b.f.Float64()
+	return f
+}
+
+// String formats the value with all decimal digits that are covered by its
+// precision, except for two guard digits that absorb rounding errors. Trailing
+// zeros are removed.
+func (b BigFloat) String() string {
+	digits := int(float64(b.f.Prec())*math.Log10(2)) - 2
+	if digits < 1 {
+		digits = 1
+	}
+	return b.f.Text('g', digits)
+}
+
+// Rat is the Value used by ModeRational.
+type Rat struct {
+	r *big.Rat
+}
+
+// NewRat creates a Rat from r. r must not be modified afterwards.
+func NewRat(r *big.Rat) Rat {
+	return Rat{r}
+}
+
+// Big returns the value as big.Rat. The returned value must not be modified.
+func (r Rat) Big() *big.Rat {
+	return r.r
+}
+
+func (r Rat) Float64() float64 {
+	f, _ := r.r.Float64()
+	return f
+}
+
+// String formats the value as fraction, e.g. 7/3. Integers are formatted without
+// denominator.
+func (r Rat) String() string {
+	return r.r.RatString()
+}
+
+// Decimal formats the value in decimal notation with the given number of digits
+// after the decimal point. The last digit is rounded to nearest, with halves
+// rounded away from zero.
+func (r Rat) Decimal(digits int) string {
+	return r.r.FloatString(digits)
+}
+
+// Complex is the Value used by ModeComplex.
+type Complex complex128
+
+// Float64 returns the real part of the value. If the imaginary part is not zero,
+// the value cannot be represented as float64 and NaN is returned.
+func (c Complex) Float64() float64 {
+	if imag(c) != 0 {
+		return math.NaN()
+	}
+	return real(c)
+}
+
+// String formats the value in rectangular form, e.g. 3+4i. The real or imaginary
+// part is omitted if it is zero.
+func (c Complex) String() string {
+	re, im := Float(real(c)), Float(imag(c))
+	if im == 0 {
+		return re.String()
+	}
+	if re == 0 {
+		return im.String() + "i"
+	}
+	if im < 0 {
+		return re.String() + im.String() + "i"
+	}
+	return re.String() + "+" + im.String() + "i"
+}
+
+// Polar formats the value in polar form, e.g. 5∠53.13°. The angle is given in
+// degrees.
+func (c Complex) Polar() string {
+	r := Float(cmplx.Abs(complex128(c)))
+	phi := Float(cmplx.Phase(complex128(c)) * 180 / math.Pi)
+	return r.String() + "∠" + phi.String() + "°"
+}
+
+// List is a Value that contains an ordered sequence of Values, e.g. [1, 2, 3]. It
+// can be used in every Mode, its elements have the number type of the Mode. Lists
+// can be nested.
+type List []Value
+
+// Float64 returns NaN, a list cannot be represented as float64.
+func (l List) Float64() float64 {
+	return math.NaN()
+}
+
+// String formats the list the way it is written in expressions, e.g. [1, 2, 3].
+func (l List) String() string {
+	elements := make([]string, len(l))
+	for i, v := range l {
+		elements[i] = v.String()
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// ValueMacro can be implemented by a Macro that supports other modes than
+// ModeFloat. Instead of Eval, EvalValue is used to evaluate the macro, the
+// parameters of the macro should be evaluated using their EvalValue method as
+// well. Macros that only implement Macro can be used in every mode, but their
+// results are limited to the precision of float64.
+type ValueMacro interface {
+	Macro
+	// EvalValue returns the value this macro resolves to, or an error if one
+	// occurs. The type of the Value should match the Mode of env.
+	EvalValue(env *Env) (Value, error)
+}
+
+// Quantity is a Value that consists of a number and a physical unit, e.g. 3 km.
+// Quantities can be used in every Mode, their values have the number type of the
+// Mode.
+type Quantity struct {
+	// Value is the number of units, it is neither a List nor a Quantity
+	Value Value
+	// Unit is the unit of the quantity, it is never empty
+	Unit Unit
+}
+
+// Float64 returns NaN, a quantity cannot be represented as float64 without losing
+// its unit.
+func (q Quantity) Float64() float64 {
+	return math.NaN()
+}
+
+// String formats the quantity the way it is written in expressions, e.g. 9.81 m/s^2.
+func (q Quantity) String() string {
+	return q.Value.String() + " " + q.Unit.String()
+}
+
+// Unit is a product of powers of units, e.g. kg*m/s^2. The units are identified by
+// their symbols, each of them appears at most once.
+type Unit []Factor
+
+// Factor is a unit raised to an integer power, e.g. s^-2. The exponent is never
+// zero.
+type Factor struct {
+	// Symbol is the symbol of the unit including its prefix, e.g. km
+	Symbol string
+	// Exponent is the power the unit is raised to
+	Exponent int
+}
+
+// String formats the unit the way it is written in expressions. Factors with
+// negative exponents are written as divisors, e.g. m/s^2, unless all exponents
+// are negative, e.g. s^-1.
+func (u Unit) String() string {
+	var numerator, denominator []string
+	for _, f := range u {
+		if f.Exponent > 0 {
+			numerator = append(numerator, f.format(f.Exponent))
+		} else {
+			denominator = append(denominator, f.format(-f.Exponent))
+		}
+	}
+	if len(numerator) == 0 {
+		factors := make([]string, len(u))
+		for i, f := range u {
+			factors[i] = f.format(f.Exponent)
+		}
+		return strings.Join(factors, "*")
+	}
+	return strings.Join(append([]string{strings.Join(numerator, "*")}, denominator...), "/")
+}
+
+// format formats the symbol of f raised to exponent, e.g. s^2.
+func (f Factor) format(exponent int) string {
+	if exponent == 1 {
+		return f.Symbol
+	}
+	return f.Symbol + "^" + strconv.Itoa(exponent)
+}
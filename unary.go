@@ -0,0 +1,55 @@
+package calc
+
+import (
+	"github.com/maxmoehl/calc/types"
+)
+
+// unaryOperation is an operation with a single operand, like the negation in -3.
+type unaryOperation struct {
+	// operator contains the operation that should be carried out on the operand
+	operator rune
+	// operand is the Node the operator is applied to
+	operand types.Node
+	span
+}
+
+// Eval evaluates the operand and applies the operator to the result.
+func (u *unaryOperation) Eval(env *types.Env) (float64, error) {
+	return evalFloat(u, env)
+}
+
+// EvalValue evaluates the operand and applies the operator to the result, or to
+// each element if the result is a list.
+func (u *unaryOperation) EvalValue(env *types.Env) (types.Value, error) {
+	v, err := u.operand.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	return broadcast([]types.Value{v}, func(args []types.Value) (types.Value, error) {
+		return u.apply(env, args[0])
+	})
+}
+
+// apply applies the operator to v, which is not a list.
+func (u *unaryOperation) apply(env *types.Env, v types.Value) (types.Value, error) {
+	switch u.operator {
+	case '+':
+		return v, nil
+	case '-':
+		return negate(v), nil
+	case '~':
+		i, err := integer(u.operator, v)
+		if err != nil {
+			return nil, wrapError(evalKind(err), u.span, err)
+		}
+		return fromInt(env, i.Not(i)), nil
+	case '¬':
+		b, err := truthy(v)
+		if err != nil {
+			return nil, wrapError(KindEval, u.span, err)
+		}
+		return boolValue(env, !b), nil
+	default:
+		return nil, newError(KindEval, u.span, "unknown unary Operation: '%s'", string(u.operator))
+	}
+}
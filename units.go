@@ -0,0 +1,687 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// ErrDimension is wrapped by errors that occur if quantities with different
+// dimensions are combined, e.g. 1 kg + 1 s.
+var ErrDimension = errors.New("incompatible dimensions")
+
+// dimension contains the exponents of the SI base units in baseUnits a unit is
+// made of, e.g. {1, 0, -2} for m/s^2. Dimensionless units like rad have the zero
+// dimension.
+type dimension [7]int
+
+// baseUnits contains the symbols of the SI base units in the order used by
+// dimension.
+var baseUnits = [len(dimension{})]string{"m", "kg", "s", "A", "K", "mol", "cd"}
+
+var (
+	distance    = dimension{1}
+	mass        = dimension{0, 1}
+	duration    = dimension{0, 0, 1}
+	current     = dimension{0, 0, 0, 1}
+	temperature = dimension{0, 0, 0, 0, 1}
+	amount      = dimension{0, 0, 0, 0, 0, 1}
+	luminosity  = dimension{0, 0, 0, 0, 0, 0, 1}
+	frequency   = dimension{0, 0, -1}
+	velocity    = dimension{1, 0, -1}
+	volume      = dimension{3}
+	force       = dimension{1, 1, -2}
+	pressure    = dimension{-1, 1, -2}
+	energy      = dimension{2, 1, -2}
+	power       = dimension{2, 1, -3}
+	charge      = dimension{0, 0, 1, 1}
+	voltage     = dimension{2, 1, -3, -1}
+	resistance  = dimension{2, 1, -3, -2}
+)
+
+// unitInfo describes a unit of unitTable.
+type unitInfo struct {
+	// scale is the value of the unit in SI base units, e.g. 1000 for t
+	scale float64
+	// dim is the dimension of the unit
+	dim dimension
+	// prefixes is set if the unit can be combined with the SI prefixes, e.g. km
+	prefixes bool
+}
+
+// unitTable contains all units that can be used in expressions. Temperature scales
+// with an offset, like degrees Celsius, are not supported.
+var unitTable = map[string]unitInfo{
+	// SI base units, the kilogram is the gram with the prefix k
+	"m":   {1, distance, true},
+	"g":   {0.001, mass, true},
+	"s":   {1, duration, true},
+	"A":   {1, current, true},
+	"K":   {1, temperature, true},
+	"mol": {1, amount, true},
+	"cd":  {1, luminosity, true},
+	// derived SI units
+	"Hz":  {1, frequency, true},
+	"N":   {1, force, true},
+	"Pa":  {1, pressure, true},
+	"J":   {1, energy, true},
+	"W":   {1, power, true},
+	"C":   {1, charge, true},
+	"V":   {1, voltage, true},
+	"ohm": {1, resistance, true},
+	"L":   {0.001, volume, true},
+	"eV":  {1.602176634e-19, energy, true},
+	"Wh":  {3600, energy, true},
+	"cal": {4.184, energy, true},
+	"bar": {100000, pressure, true},
+	// time
+	"min": {60, duration, false},
+	"h":   {3600, duration, false},
+	"d":   {86400, duration, false},
+	"wk":  {604800, duration, false},
+	"yr":  {31557600, duration, false},
+	// length
+	"inch": {0.0254, distance, false},
+	"ft":   {0.3048, distance, false},
+	"yd":   {0.9144, distance, false},
+	"mi":   {1609.344, distance, false},
+	"nmi":  {1852, distance, false},
+	"au":   {149597870700, distance, false},
+	"ly":   {9460730472580800, distance, false},
+	// mass
+	"t":  {1000, mass, false},
+	"lb": {0.45359237, mass, false},
+	"oz": {0.028349523125, mass, false},
+	// velocity
+	"mph": {0.44704, velocity, false},
+	"kn":  {1852.0 / 3600, velocity, false},
+	// miscellaneous
+	"atm": {101325, pressure, false},
+	"psi": {6894.757293168361, pressure, false},
+	"hp":  {745.69987158227022, power, false},
+	"rad": {1, dimension{}, false},
+	"deg": {math.Pi / 180, dimension{}, false},
+}
+
+// prefixes contains the SI prefixes and their values. The prefix micro is written
+// as u.
+var prefixes = map[string]float64{
+	"Y": 1e24, "Z": 1e21, "E": 1e18, "P": 1e15, "T": 1e12, "G": 1e9, "M": 1e6,
+	"k": 1e3, "h": 1e2, "da": 1e1, "d": 1e-1, "c": 1e-2, "m": 1e-3, "u": 1e-6,
+	"n": 1e-9, "p": 1e-12, "f": 1e-15, "a": 1e-18, "z": 1e-21, "y": 1e-24,
+}
+
+// lookupUnit returns the unit with the given symbol, which may start with one of
+// prefixes, and the value of the prefix, which is 1 if there is none. Symbols in
+// unitTable take precedence, e.g. min is the minute and not a milli-inch.
+func lookupUnit(symbol string) (unitInfo, float64, bool) {
+	if u, ok := unitTable[symbol]; ok {
+		return u, 1, true
+	}
+	for prefix, value := range prefixes {
+		if !strings.HasPrefix(symbol, prefix) {
+			continue
+		}
+		if u, ok := unitTable[symbol[len(prefix):]]; ok && u.prefixes {
+			return u, value, true
+		}
+	}
+	return unitInfo{}, 0, false
+}
+
+// isUnit checks if symbol is the symbol of a unit, see lookupUnit.
+func isUnit(symbol string) bool {
+	_, _, ok := lookupUnit(symbol)
+	return ok
+}
+
+// quantity is a number or an expression in parentheses followed by a unit, e.g.
+// 3 km, 9.81 m/s^2 or (1/3) h. It evaluates
+// to a types.Quantity, or to a number if the unit is dimensionless, e.g. 30 deg.
+type quantity struct {
+	value types.Node
+	unit  types.Unit
+	span
+}
+
+func (q *quantity) Eval(env *types.Env) (float64, error) {
+	return evalFloat(q, env)
+}
+
+// EvalValue evaluates the number and multiplies it with the unit.
+func (q *quantity) EvalValue(env *types.Env) (types.Value, error) {
+	v, err := q.value.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := newQuantity(env, fromInt(env, big.NewInt(1)), q.unit)
+	if err == nil {
+		v, err = calcValue(env, '*', v, unit)
+	}
+	if err != nil {
+		return nil, wrapError(evalKind(err), q.span, err)
+	}
+	return v, nil
+}
+
+// conversion converts a quantity to another unit of the same dimension, e.g.
+// 60 mph in m/s. Unlike other operations, the result keeps a dimensionless unit,
+// so angles can be converted as well, e.g. pi in deg.
+type conversion struct {
+	value types.Node
+	unit  types.Unit
+	span
+}
+
+func (c *conversion) Eval(env *types.Env) (float64, error) {
+	return evalFloat(c, env)
+}
+
+// EvalValue evaluates the quantity and converts it, or each of its elements if it
+// is a list.
+func (c *conversion) EvalValue(env *types.Env) (types.Value, error) {
+	v, err := c.value.EvalValue(env)
+	if err != nil {
+		return nil, err
+	}
+	res, err := broadcast([]types.Value{v}, func(args []types.Value) (types.Value, error) {
+		x, err := convertQuantity(env, args[0], c.unit)
+		if err != nil || len(c.unit) == 0 {
+			return x, err
+		}
+		return types.Quantity{Value: x, Unit: c.unit}, nil
+	})
+	if err != nil {
+		return nil, wrapError(evalKind(err), c.span, err)
+	}
+	return res, nil
+}
+
+// isUnit checks if the Token at index i is the symbol of a unit. Identifiers that
+// refer to parameters or are followed by a brace, e.g. min{1, 2}, are no units.
+// Variables and constants are not taken into account, since variables are only
+// known when the expression is evaluated, so 2 m always is a length.
+func (p *parser) isUnit(i int) bool {
+	if i >= len(p.tokens) || p.tokens[i].Type() != typeIdentifier {
+		return false
+	}
+	id := p.tokens[i].Value().(string)
+	if p.function.isParameter(id) || p.isLocal(id) || !isUnit(id) {
+		return false
+	}
+	return i+1 == len(p.tokens) || p.tokens[i+1].Value() != '{'
+}
+
+// parseQuantity parses the unit following the number or parenthesis n, e.g. the km
+// in 3 km.
+func (p *parser) parseQuantity(n types.Node) (types.Node, error) {
+	unit, s, err := p.parseUnit()
+	if err != nil {
+		return nil, err
+	}
+	return &quantity{n, unit, spanOf(n).join(s)}, nil
+}
+
+// parseConversion parses the unit after the operator in or to, which has already
+// been consumed, and creates the conversion of n.
+func (p *parser) parseConversion(n types.Node, op Token) (types.Node, error) {
+	if !p.isUnit(p.i) {
+		var err *Error
+		switch {
+		case p.done():
+			err = newError(KindSyntax, p.endOfInput(), "unexpected end of expression, expected a unit after '%s'", tokenString(op))
+		case p.peek().Type() == typeIdentifier:
+			id := p.peek().Value().(string)
+			err = newError(KindSyntax, spanOf(p.peek()), "unknown unit '%s'", id)
+			err.Token = id
+		default:
+			err = p.unexpected()
+			err.Hint = fmt.Sprintf("'%s' has to be followed by a unit, e.g. 1 km %s m", tokenString(op), tokenString(op))
+		}
+		return p.skip(err)
+	}
+	unit, s, err := p.parseUnit()
+	if err != nil {
+		return nil, err
+	}
+	return &conversion{n, unit, spanOf(n).join(s)}, nil
+}
+
+// parseUnit parses a product of units starting at the next Token, which has to be
+// a unit, e.g. kg*m/s^2. Units are only read after * and / if they are followed by
+// a unit, so 3 m * 2 is parsed as (3 m) * 2.
+func (p *parser) parseUnit() (types.Unit, span, error) {
+	var unit types.Unit
+	s := spanOf(p.peek())
+	sign := 1
+	for {
+		t := p.next()
+		f := types.Factor{Symbol: t.Value().(string), Exponent: sign}
+		s = s.join(spanOf(t))
+		if p.accept(typeOperator, '^') {
+			e, es, err := p.parseUnitExponent(f.Symbol)
+			if err != nil {
+				return nil, s, err
+			}
+			f.Exponent *= e
+			s = s.join(es)
+		}
+		unit = multiplyFactor(unit, f)
+		if p.done() || (p.peek().Value() != '*' && p.peek().Value() != '/') || !p.isUnit(p.i+1) {
+			return unit, s, nil
+		}
+		sign = 1
+		if p.next().Value() == '/' {
+			sign = -1
+		}
+	}
+}
+
+// parseUnitExponent parses the exponent of the unit with the given symbol after
+// the ^, which has already been consumed and has to be followed by an integer,
+// e.g. the -2 in s^-2. If the exponent is invalid, 1 is used in recovery mode.
+func (p *parser) parseUnitExponent(symbol string) (int, span, error) {
+	caret := spanOf(p.tokens[p.i-1])
+	negative := p.accept(typeOperator, '-')
+	if !p.done() && p.peek().Type() == typeLiteral {
+		if text, ok := p.peek().Value().(string); ok {
+			if e, err := strconv.Atoi(text); err == nil {
+				t := p.next()
+				if negative {
+					e = -e
+				}
+				return e, caret.join(spanOf(t)), nil
+			}
+		}
+	}
+	var err *Error
+	if p.done() {
+		err = newError(KindSyntax, p.endOfInput(), "unexpected end of expression, expected the exponent of unit %s", symbol)
+	} else {
+		err = newError(KindSyntax, spanOf(p.peek()), "the exponent of unit %s has to be an integer", symbol)
+		err.Token = tokenString(p.peek())
+	}
+	if _, err := p.skip(err); err != nil {
+		return 0, span{}, err
+	}
+	return 1, caret, nil
+}
+
+// multiplyFactor multiplies unit with f. If unit contains the symbol of f, the
+// exponents are added and factors with the exponent 0 are removed.
+func multiplyFactor(unit types.Unit, f types.Factor) types.Unit {
+	for i, g := range unit {
+		if g.Symbol != f.Symbol {
+			continue
+		}
+		res := append(types.Unit(nil), unit...)
+		res[i].Exponent += f.Exponent
+		if res[i].Exponent == 0 {
+			res = append(res[:i], res[i+1:]...)
+		}
+		return res
+	}
+	return append(unit, f)
+}
+
+// dimensionOf returns the dimension of unit. All symbols of unit have to be known
+// units, see lookupUnit.
+func dimensionOf(unit types.Unit) dimension {
+	var d dimension
+	for _, f := range unit {
+		u, _, _ := lookupUnit(f.Symbol)
+		for i := range d {
+			d[i] += u.dim[i] * f.Exponent
+		}
+	}
+	return d
+}
+
+// baseUnit returns the unit of the dimension d that consists of SI base units,
+// e.g. kg*m/s^2 for the dimension of a force.
+func baseUnit(d dimension) types.Unit {
+	var unit types.Unit
+	for i, e := range d {
+		if e != 0 {
+			unit = append(unit, types.Factor{Symbol: baseUnits[i], Exponent: e})
+		}
+	}
+	return unit
+}
+
+// scaleOf returns the value of unit in SI base units using the number type of the
+// Mode of env. The prefixes and units are converted separately, so the scale is
+// exact in types.ModeRational, e.g. 4184 for kcal.
+func scaleOf(env *types.Env, unit types.Unit) (types.Value, error) {
+	res := fromInt(env, big.NewInt(1))
+	for _, f := range unit {
+		u, prefix, _ := lookupUnit(f.Symbol)
+		p, err := convertValue(env, types.Float(prefix))
+		if err != nil {
+			return nil, err
+		}
+		s, err := convertValue(env, types.Float(u.scale))
+		if err != nil {
+			return nil, err
+		}
+		if s, err = calcValue(env, '*', p, s); err != nil {
+			return nil, err
+		}
+		if s, err = calcValue(env, '^', s, fromInt(env, big.NewInt(int64(f.Exponent)))); err != nil {
+			return nil, err
+		}
+		if res, err = calcValue(env, '*', res, s); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// split returns the number and the unit of v. Numbers have no unit.
+func split(v types.Value) (types.Value, types.Unit) {
+	if q, ok := v.(types.Quantity); ok {
+		return q.Value, q.Unit
+	}
+	return v, nil
+}
+
+// newQuantity creates the quantity of v units. If the unit is dimensionless, e.g.
+// km/m, the quantity is converted to a number.
+func newQuantity(env *types.Env, v types.Value, unit types.Unit) (types.Value, error) {
+	if len(unit) == 0 {
+		return v, nil
+	}
+	if dimensionOf(unit) == (dimension{}) {
+		return convertTo(env, v, unit, nil)
+	}
+	return types.Quantity{Value: v, Unit: unit}, nil
+}
+
+// equalUnits checks if a and b consist of the same factors in the same order.
+func equalUnits(a, b types.Unit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// convertTo converts v from the unit from to the unit to, which have to have the
+// same dimension.
+func convertTo(env *types.Env, v types.Value, from, to types.Unit) (types.Value, error) {
+	if equalUnits(from, to) {
+		return v, nil
+	}
+	f, err := scaleOf(env, from)
+	if err != nil {
+		return nil, err
+	}
+	t, err := scaleOf(env, to)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = calcValue(env, '*', v, f); err != nil {
+		return nil, err
+	}
+	return calcValue(env, '/', v, t)
+}
+
+// convertQuantity returns the number of units of unit v corresponds to. An error
+// is returned if the dimensions do not match.
+func convertQuantity(env *types.Env, v types.Value, unit types.Unit) (types.Value, error) {
+	x, from := split(v)
+	if dimensionOf(from) != dimensionOf(unit) {
+		if len(unit) == 0 {
+			return nil, fmt.Errorf("%v is not a dimensionless number: %w", v, ErrDimension)
+		}
+		return nil, fmt.Errorf("cannot convert %v to %s: %w", v, unit, ErrDimension)
+	}
+	return convertTo(env, x, from, unit)
+}
+
+// multiplyUnits returns the unit of the product of quantities with the units a and
+// b, or of the quotient if divide is set, and the factor the product of the
+// numbers has to be multiplied with. Units of b that have the same dimension as a
+// unit of a are converted to that unit, e.g. km*m is km^2/1000.
+func multiplyUnits(env *types.Env, a, b types.Unit, divide bool) (types.Unit, types.Value, error) {
+	res := append(types.Unit(nil), a...)
+	factor := fromInt(env, big.NewInt(1))
+	for _, f := range b {
+		if divide {
+			f.Exponent = -f.Exponent
+		}
+		if g, ok := matchingFactor(res, f); ok && g.Symbol != f.Symbol {
+			to := types.Factor{Symbol: g.Symbol, Exponent: f.Exponent}
+			c, err := convertTo(env, factor, types.Unit{f}, types.Unit{to})
+			if err != nil {
+				return nil, nil, err
+			}
+			factor, f = c, to
+		}
+		res = multiplyFactor(res, f)
+	}
+	return res, factor, nil
+}
+
+// matchingFactor returns the factor of unit f can be merged with, which is the
+// factor with the same symbol or, if there is none, with the same dimension.
+func matchingFactor(unit types.Unit, f types.Factor) (types.Factor, bool) {
+	for _, g := range unit {
+		if g.Symbol == f.Symbol {
+			return g, true
+		}
+	}
+	d := dimensionOf(types.Unit{{Symbol: f.Symbol, Exponent: 1}})
+	for _, g := range unit {
+		if dimensionOf(types.Unit{{Symbol: g.Symbol, Exponent: 1}}) == d {
+			return g, true
+		}
+	}
+	return types.Factor{}, false
+}
+
+// powerUnit returns the unit of a quantity with v units of unit raised to the
+// power of exp and the number of units that is raised to the power. If the
+// exponents of unit cannot be multiplied with exp, e.g. for the square root of
+// km*m, the quantity is converted to SI base units first.
+func powerUnit(env *types.Env, v types.Value, unit types.Unit, exp *big.Rat) (types.Value, types.Unit, error) {
+	res, ok := raiseUnit(unit, exp)
+	if !ok {
+		base := baseUnit(dimensionOf(unit))
+		if res, ok = raiseUnit(base, exp); ok {
+			var err error
+			if v, err = convertTo(env, v, unit, base); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("the unit %s cannot be raised to the power of %s: %w", unit, exp.RatString(), ErrDimension)
+	}
+	return v, res, nil
+}
+
+// raiseUnit multiplies the exponents of unit with exp and reports whether all of
+// the results are integers.
+func raiseUnit(unit types.Unit, exp *big.Rat) (types.Unit, bool) {
+	var res types.Unit
+	for _, f := range unit {
+		e := new(big.Rat).Mul(big.NewRat(int64(f.Exponent), 1), exp)
+		if !e.IsInt() || !e.Num().IsInt64() || e.Num().Int64() > math.MaxInt32 || e.Num().Int64() < math.MinInt32 {
+			return nil, false
+		}
+		if e.Sign() != 0 {
+			res = append(res, types.Factor{Symbol: f.Symbol, Exponent: int(e.Num().Int64())})
+		}
+	}
+	return res, true
+}
+
+// calcQuantity carries out the arithmetic operation indicated by operator on left
+// and right, at least one of which is a quantity. Products and quotients combine
+// the units, sums, differences and remainders have the unit of left, the operand
+// right is converted to it.
+func calcQuantity(env *types.Env, operator rune, left, right types.Value) (types.Value, error) {
+	l, lu := split(left)
+	r, ru := split(right)
+	switch operator {
+	case '*', '/':
+		unit, factor, err := multiplyUnits(env, lu, ru, operator == '/')
+		if err != nil {
+			return nil, err
+		}
+		v, err := calcValue(env, operator, l, r)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = calcValue(env, '*', v, factor); err != nil {
+			return nil, err
+		}
+		return newQuantity(env, v, unit)
+	case '^':
+		if dimensionOf(ru) != (dimension{}) {
+			return nil, fmt.Errorf("the exponent %v has to be a dimensionless number: %w", right, ErrDimension)
+		}
+		r, err := convertTo(env, r, ru, nil)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := toRat(r)
+		if err != nil {
+			return nil, err
+		}
+		l, unit, err := powerUnit(env, l, lu, exp)
+		if err != nil {
+			return nil, err
+		}
+		v, err := calcValue(env, '^', l, r)
+		if err != nil {
+			return nil, err
+		}
+		return newQuantity(env, v, unit)
+	}
+	if dimensionOf(lu) != dimensionOf(ru) {
+		return nil, fmt.Errorf("%v and %v cannot be combined with %s: %w", left, right, operatorString(operator), ErrDimension)
+	}
+	r, err := convertTo(env, r, ru, lu)
+	if err != nil {
+		return nil, err
+	}
+	v, err := calcValue(env, operator, l, r)
+	if err != nil || operator == '÷' {
+		// the integer quotient is a number
+		return v, err
+	}
+	return newQuantity(env, v, lu)
+}
+
+// compareQuantities compares left and right, at least one of which is a quantity.
+// right is converted to the unit of left first.
+func compareQuantities(env *types.Env, operator rune, left, right types.Value) (bool, error) {
+	l, lu := split(left)
+	r, ru := split(right)
+	if dimensionOf(lu) != dimensionOf(ru) {
+		return false, fmt.Errorf("%v and %v cannot be compared with %s: %w", left, right, operatorString(operator), ErrDimension)
+	}
+	r, err := convertTo(env, r, ru, lu)
+	if err != nil {
+		return false, err
+	}
+	return compare(env, operator, l, r)
+}
+
+// unitRule determines how a function handles arguments that contain quantities.
+// It returns the numbers that are passed to the function in place of args and the
+// unit of the result.
+type unitRule func(env *types.Env, args []types.Value) ([]types.Value, types.Unit, error)
+
+// withUnits sets the unitRule of the functions created by newFunction. Functions
+// without unitRule use noUnits.
+func withUnits(newFunction types.NewMacro, rule unitRule) types.NewMacro {
+	return func(parameters []types.Node) (types.Macro, error) {
+		m, err := newFunction(parameters)
+		if err != nil {
+			return nil, err
+		}
+		m.(*function).units = rule
+		return m, nil
+	}
+}
+
+// noUnits is the unitRule of functions that only accept dimensionless arguments,
+// e.g. sin. Quantities with a dimensionless unit, e.g. 90 deg, are converted to
+// numbers.
+func noUnits(env *types.Env, args []types.Value) ([]types.Value, types.Unit, error) {
+	values := make([]types.Value, len(args))
+	for i, a := range args {
+		v, err := convertQuantity(env, a, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[i] = v
+	}
+	return values, nil, nil
+}
+
+// sameUnit is the unitRule of functions whose arguments and result have the same
+// unit, e.g. abs or max. All arguments are converted to the unit of the first
+// quantity among them.
+func sameUnit(env *types.Env, args []types.Value) ([]types.Value, types.Unit, error) {
+	var unit types.Unit
+	for _, a := range args {
+		if q, ok := a.(types.Quantity); ok {
+			unit = q.Unit
+			break
+		}
+	}
+	values := make([]types.Value, len(args))
+	for i, a := range args {
+		v, err := convertQuantity(env, a, unit)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[i] = v
+	}
+	return values, unit, nil
+}
+
+// nthRoot returns the unitRule of the n-th root, which divides the exponents of the
+// unit by n, e.g. sqrt{4 m^2} is 2 m.
+func nthRoot(n int64) unitRule {
+	return func(env *types.Env, args []types.Value) ([]types.Value, types.Unit, error) {
+		v, unit := split(args[0])
+		v, unit, err := powerUnit(env, v, unit, big.NewRat(1, n))
+		if err != nil {
+			return nil, nil, err
+		}
+		return []types.Value{v}, unit, nil
+	}
+}
+
+// powerOf is the unitRule of pow{x, y}, which multiplies the exponents of the unit
+// of x by y, like x^y.
+func powerOf(env *types.Env, args []types.Value) ([]types.Value, types.Unit, error) {
+	exp, _, err := noUnits(env, args[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := toRat(exp[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	v, unit := split(args[0])
+	v, unit, err = powerUnit(env, v, unit, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []types.Value{v, exp[0]}, unit, nil
+}
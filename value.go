@@ -0,0 +1,844 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+
+	"github.com/maxmoehl/calc/types"
+)
+
+// errDivisionByZero is returned if a division by zero cannot be represented in the
+// current mode.
+var errDivisionByZero = errors.New("division by zero")
+
+// evaluable is implemented by all Nodes and by the built-in macros.
+type evaluable interface {
+	EvalValue(env *types.Env) (types.Value, error)
+}
+
+// evalFloat evaluates n and converts the result to float64. It is used to
+// implement Eval for all nodes and built-in macros based on their EvalValue
+// method. If the result cannot be converted, the error is attributed to n.
+func evalFloat(n evaluable, env *types.Env) (float64, error) {
+	v, err := n.EvalValue(env)
+	if err != nil {
+		return math.NaN(), err
+	}
+	f, err := toFloat(v)
+	if err != nil {
+		return math.NaN(), wrapError(KindEval, spanOf(n), err)
+	}
+	return f, nil
+}
+
+// toFloat converts v to float64. Lists, quantities and complex numbers with an
+// imaginary part cannot be represented as float64, for them an error is returned.
+func toFloat(v types.Value) (float64, error) {
+	if err := scalar(v); err != nil {
+		return math.NaN(), err
+	}
+	switch x := v.(type) {
+	case types.Quantity:
+		return math.NaN(), fmt.Errorf("%v has the unit %s but a number is expected", v, x.Unit)
+	case types.Complex:
+		if imag(x) != 0 {
+			return math.NaN(), fmt.Errorf("%v is %w, use EvalValue to get complex results", v, ErrNotReal)
+		}
+	}
+	return v.Float64(), nil
+}
+
+// isNumber checks if v is a number, i.e. neither a list nor a quantity. nil is
+// treated as a number.
+func isNumber(v types.Value) bool {
+	switch v.(type) {
+	case types.List, types.Quantity:
+		return false
+	}
+	return true
+}
+
+// calcValue carries out the operation indicated by operator on left and right
+// using the number type selected by the Mode of env. If one of the operands is a
+// list, the operation is carried out for each element, see broadcast, except for
+// the matrix multiplication @. Quantities are handled by calcQuantity.
+func calcValue(env *types.Env, operator rune, left, right types.Value) (types.Value, error) {
+	if operator == '@' {
+		return matmul(env, left, right)
+	}
+	if _, ok := left.(types.List); ok {
+		return broadcastOperation(env, operator, left, right)
+	}
+	if _, ok := right.(types.List); ok {
+		return broadcastOperation(env, operator, left, right)
+	}
+	if isBitwise(operator) {
+		z, err := calcInt(operator, left, right)
+		if err != nil {
+			return nil, err
+		}
+		return fromInt(env, z), nil
+	}
+	if isComparison(operator) {
+		b, err := compare(env, operator, left, right)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(env, b), nil
+	}
+	if _, ok := left.(types.Quantity); ok {
+		return calcQuantity(env, operator, left, right)
+	}
+	if _, ok := right.(types.Quantity); ok {
+		return calcQuantity(env, operator, left, right)
+	}
+	switch env.Mode() {
+	case types.ModeBigFloat:
+		return calcBig(operator, left, right, env.Precision())
+	case types.ModeRational:
+		return calcRat(operator, left, right)
+	case types.ModeComplex:
+		return calcComplex(operator, left, right)
+	default:
+		res, err := calc(operator, left.Float64(), right.Float64())
+		if err != nil {
+			return nil, err
+		}
+		return types.Float(res), nil
+	}
+}
+
+// broadcastOperation carries out the operation for each element of the lists left
+// and right, see calcValue.
+func broadcastOperation(env *types.Env, operator rune, left, right types.Value) (types.Value, error) {
+	return broadcast([]types.Value{left, right}, func(args []types.Value) (types.Value, error) {
+		return calcValue(env, operator, args[0], args[1])
+	})
+}
+
+// negate returns -v, keeping the type of v. Lists are negated element-wise.
+func negate(v types.Value) types.Value {
+	switch n := v.(type) {
+	case types.Quantity:
+		return types.Quantity{Value: negate(n.Value), Unit: n.Unit}
+	case types.List:
+		res := make(types.List, len(n))
+		for i, e := range n {
+			res[i] = negate(e)
+		}
+		return res
+	case types.BigFloat:
+		return types.NewBigFloat(new(big.Float).Neg(n.Big()))
+	case types.Rat:
+		return types.NewRat(new(big.Rat).Neg(n.Big()))
+	case types.Complex:
+		// 0-n instead of -n keeps the imaginary part of real numbers at +0, which
+		// puts -4 on the same side of the branch cut of sqrt as the literal -4
+		return 0 - n
+	default:
+		return types.Float(-v.Float64())
+	}
+}
+
+// errComplexMode is returned if an imaginary literal is used in a mode that does
+// not support complex numbers.
+var errComplexMode = errors.New("imaginary numbers can only be used in complex mode")
+
+// parseValue converts the literal text to a Value in the Mode of env.
+func parseValue(env *types.Env, text string) (types.Value, error) {
+	if isImaginary(text) {
+		if env.Mode() != types.ModeComplex {
+			return nil, errComplexMode
+		}
+		f, err := convertLiteral([]rune(strings.TrimSuffix(text, "i")))
+		if err != nil {
+			return nil, err
+		}
+		return types.Complex(complex(0, f)), nil
+	}
+	switch env.Mode() {
+	case types.ModeBigFloat:
+		f, _, err := big.ParseFloat(text, 10, env.Precision(), big.ToNearestEven)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert %s: %w", text, err)
+		}
+		return types.NewBigFloat(f), nil
+	case types.ModeRational:
+		r, ok := new(big.Rat).SetString(text)
+		if !ok {
+			return nil, fmt.Errorf("unable to convert %s to a fraction", text)
+		}
+		return types.NewRat(r), nil
+	default:
+		f, err := convertLiteral([]rune(text))
+		if err != nil {
+			return nil, err
+		}
+		return types.Float(f), nil
+	}
+}
+
+// convertValue converts v to the number type of the Mode of env. The elements of
+// lists are converted individually, quantities keep their unit.
+func convertValue(env *types.Env, v types.Value) (types.Value, error) {
+	if q, ok := v.(types.Quantity); ok {
+		c, err := convertValue(env, q.Value)
+		if err != nil {
+			return nil, err
+		}
+		return types.Quantity{Value: c, Unit: q.Unit}, nil
+	}
+	if l, ok := v.(types.List); ok {
+		res := make(types.List, len(l))
+		for i, e := range l {
+			c, err := convertValue(env, e)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = c
+		}
+		return res, nil
+	}
+	switch env.Mode() {
+	case types.ModeBigFloat:
+		f, err := toBig(v, env.Precision())
+		if err != nil {
+			return nil, err
+		}
+		return types.NewBigFloat(f), nil
+	case types.ModeRational:
+		r, err := toRat(v)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewRat(r), nil
+	case types.ModeComplex:
+		return types.Complex(toComplex(v)), nil
+	default:
+		return types.Float(v.Float64()), nil
+	}
+}
+
+// toBig converts v to a big.Float. Values that are no BigFloat yet are converted
+// with the given precision. Instead of the exact binary value of the float64, the
+// shortest decimal number that identifies it is used, e.g. 0.1 is converted to
+// 0.1 instead of 0.1000000000000000055511151231257827.
+func toBig(v types.Value, prec uint) (*big.Float, error) {
+	if b, ok := v.(types.BigFloat); ok {
+		return b.Big(), nil
+	}
+	f := v.Float64()
+	if math.IsNaN(f) {
+		return nil, fmt.Errorf("NaN cannot be represented as big.Float")
+	}
+	if math.IsInf(f, 0) {
+		return new(big.Float).SetPrec(prec).SetInf(f < 0), nil
+	}
+	b, _, err := big.ParseFloat(strconv.FormatFloat(f, 'g', -1, 64), 10, prec, big.ToNearestEven)
+	return b, err
+}
+
+// calcBig carries out the operation indicated by operator on left and right using
+// big.Float with the precision prec.
+func calcBig(operator rune, left, right types.Value, prec uint) (res types.Value, err error) {
+	l, err := toBig(left, prec)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toBig(right, prec)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// big.Float panics if the result would be NaN, e.g. for Inf-Inf
+		if p := recover(); p != nil {
+			e, ok := p.(big.ErrNaN)
+			if !ok {
+				panic(p)
+			}
+			res, err = nil, errors.New(e.Error())
+		}
+	}()
+	z := new(big.Float).SetPrec(prec)
+	switch operator {
+	case '+':
+		z.Add(l, r)
+	case '-':
+		z.Sub(l, r)
+	case '*':
+		z.Mul(l, r)
+	case '/':
+		if r.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		z.Quo(l, r)
+	case '÷', '%':
+		if r.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		q := bigFloor([]*big.Float{z.Quo(l, r)}, prec)
+		if operator == '÷' {
+			return types.NewBigFloat(q), nil
+		}
+		z.Sub(l, q.Mul(q, r))
+	case '^':
+		return powBig(l, r, prec)
+	default:
+		return nil, fmt.Errorf("unknown Operation: '%s'", operatorString(operator))
+	}
+	return types.NewBigFloat(z), nil
+}
+
+// powBig calculates base^exp. Integer exponents are calculated with the precision
+// prec by repeated squaring, all other exponents are calculated using float64.
+func powBig(base, exp *big.Float, prec uint) (types.Value, error) {
+	n, acc := exp.Int64()
+	if !exp.IsInt() || acc != big.Exact {
+		f, _ := base.Float64()
+		e, _ := exp.Float64()
+		res := math.Pow(f, e)
+		if math.IsNaN(res) {
+			return nil, fmt.Errorf("(%g)^(%g) is not a real number", f, e)
+		}
+		b, err := toBig(types.Float(res), prec)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewBigFloat(b), nil
+	}
+	if n < 0 && base.Sign() == 0 {
+		return nil, errDivisionByZero
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	res := new(big.Float).SetPrec(prec).SetInt64(1)
+	b := new(big.Float).SetPrec(prec).Set(base)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			res.Mul(res, b)
+		}
+		b.Mul(b, b)
+	}
+	if negative {
+		res.Quo(new(big.Float).SetPrec(prec).SetInt64(1), res)
+	}
+	return types.NewBigFloat(res), nil
+}
+
+// toRat converts v to a big.Rat. Like toBig, float64 values are converted using the
+// shortest decimal number that identifies them.
+func toRat(v types.Value) (*big.Rat, error) {
+	switch n := v.(type) {
+	case types.Rat:
+		return n.Big(), nil
+	case types.BigFloat:
+		if n.Big().IsInf() {
+			return nil, fmt.Errorf("%v cannot be represented as fraction", v)
+		}
+		r, _ := n.Big().Rat(nil)
+		return r, nil
+	}
+	f := v.Float64()
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%g cannot be represented as fraction", f)
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return r, nil
+}
+
+// calcRat carries out the operation indicated by operator on left and right using
+// big.Rat.
+func calcRat(operator rune, left, right types.Value) (types.Value, error) {
+	l, err := toRat(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toRat(right)
+	if err != nil {
+		return nil, err
+	}
+	z := new(big.Rat)
+	switch operator {
+	case '+':
+		z.Add(l, r)
+	case '-':
+		z.Sub(l, r)
+	case '*':
+		z.Mul(l, r)
+	case '/':
+		if r.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		z.Quo(l, r)
+	case '÷', '%':
+		if r.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		q := ratFloor([]*big.Rat{z.Quo(l, r)})
+		if operator == '÷' {
+			return types.NewRat(q), nil
+		}
+		z.Sub(l, q.Mul(q, r))
+	case '^':
+		return powRat(l, r)
+	default:
+		return nil, fmt.Errorf("unknown Operation: '%s'", operatorString(operator))
+	}
+	return types.NewRat(z), nil
+}
+
+// powRat calculates base^exp exactly. Integer exponents are calculated by repeated
+// squaring, for all other exponents the root of base given by the denominator of exp
+// has to be rational, e.g. (4/9)^(3/2) is 8/27, while 2^(1/2) returns an error that
+// wraps ErrNotRational.
+func powRat(base, exp *big.Rat) (types.Value, error) {
+	if !exp.IsInt() {
+		if base.Sign() < 0 {
+			return nil, fmt.Errorf("%s^(%s) is not a real number", ratOperand(base), exp.RatString())
+		}
+		// larger roots than the number of bits of base are only rational for 0 and 1
+		n := int64(math.MaxInt64)
+		if exp.Denom().IsInt64() {
+			n = exp.Denom().Int64()
+		}
+		root := ratRoot(base, n)
+		if root == nil {
+			return nil, fmt.Errorf("%s^(%s) is %w", ratOperand(base), exp.RatString(), ErrNotRational)
+		}
+		base, exp = root, new(big.Rat).SetInt(exp.Num())
+	}
+	if !exp.Num().IsInt64() {
+		return nil, fmt.Errorf("%s^%s is too large to calculate exactly", ratOperand(base), exp.RatString())
+	}
+	n := exp.Num().Int64()
+	if n < 0 && base.Sign() == 0 {
+		return nil, errDivisionByZero
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	// the numerator and denominator of the result have about n times as many bits
+	// as those of base
+	bits := int64(base.Num().BitLen())
+	if d := int64(base.Denom().BitLen()); d > bits {
+		bits = d
+	}
+	if bits > 1 && n > maxPowBits/(bits-1) {
+		return nil, fmt.Errorf("%s^%s is too large to calculate exactly", ratOperand(base), exp.RatString())
+	}
+	res := big.NewRat(1, 1)
+	b := new(big.Rat).Set(base)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			res.Mul(res, b)
+		}
+		b.Mul(b, b)
+	}
+	if negative {
+		res.Inv(res)
+	}
+	return types.NewRat(res), nil
+}
+
+// ratOperand formats r as operand of an operator in error messages, fractions and
+// negative numbers are put in parentheses.
+func ratOperand(r *big.Rat) string {
+	if !r.IsInt() || r.Sign() < 0 {
+		return "(" + r.RatString() + ")"
+	}
+	return r.RatString()
+}
+
+// ratRoot returns the n-th root of x, which must not be negative. If the root is
+// not rational, nil is returned.
+func ratRoot(x *big.Rat, n int64) *big.Rat {
+	num := intRoot(x.Num(), n)
+	if num == nil {
+		return nil
+	}
+	denom := intRoot(x.Denom(), n)
+	if denom == nil {
+		return nil
+	}
+	return new(big.Rat).SetFrac(num, denom)
+}
+
+// intRoot returns the n-th root of x, which must not be negative, if it is an
+// integer and nil otherwise. The root is calculated using Newton's method.
+func intRoot(x *big.Int, n int64) *big.Int {
+	if x.Cmp(big.NewInt(1)) <= 0 {
+		return new(big.Int).Set(x)
+	}
+	bits := int64(x.BitLen())
+	if n >= bits {
+		// 1 < root < 2
+		return nil
+	}
+	// start with a power of two that is larger than the root, every step of
+	// Newton's method decreases r until it is the root rounded down
+	r := new(big.Int).Lsh(big.NewInt(1), uint((bits+n-1)/n))
+	big1, bigN := big.NewInt(1), big.NewInt(n)
+	p, next := new(big.Int), new(big.Int)
+	for {
+		// next = ((n-1)r + x/r^(n-1)) / n
+		p.Exp(r, big.NewInt(n-1), nil)
+		next.Quo(x, p)
+		p.Mul(r, p.Sub(bigN, big1))
+		next.Add(next, p)
+		next.Quo(next, bigN)
+		if next.Cmp(r) >= 0 {
+			break
+		}
+		r.Set(next)
+	}
+	if p.Exp(r, bigN, nil).Cmp(x) != 0 {
+		return nil
+	}
+	return r
+}
+
+// isImaginary checks if the literal text has the imaginary suffix i.
+func isImaginary(text string) bool {
+	return strings.HasSuffix(text, "i")
+}
+
+// toComplex converts v to a complex128.
+func toComplex(v types.Value) complex128 {
+	if c, ok := v.(types.Complex); ok {
+		return complex128(c)
+	}
+	return complex(v.Float64(), 0)
+}
+
+// calcComplex carries out the operation indicated by operator on left and right
+// using complex128.
+func calcComplex(operator rune, left, right types.Value) (types.Value, error) {
+	l, r := toComplex(left), toComplex(right)
+	switch operator {
+	case '+':
+		return types.Complex(l + r), nil
+	case '-':
+		return types.Complex(l - r), nil
+	case '*':
+		return types.Complex(l * r), nil
+	case '/':
+		return types.Complex(l / r), nil
+	case '^':
+		return types.Complex(powComplex(l, r)), nil
+	case '÷', '%':
+		if imag(l) != 0 || imag(r) != 0 {
+			return nil, fmt.Errorf("complex operands of %s are %w", operatorString(operator), types.ErrDomain)
+		}
+		res, err := calc(operator, real(l), real(r))
+		if err != nil {
+			return nil, err
+		}
+		return types.Complex(complex(res, 0)), nil
+	default:
+		return nil, fmt.Errorf("unknown Operation: '%s'", operatorString(operator))
+	}
+}
+
+// powComplex calculates base^exp. Integer exponents are calculated by repeated
+// squaring, which avoids the rounding errors of cmplx.Pow, e.g. for (2i)^2.
+func powComplex(base, exp complex128) complex128 {
+	e := real(exp)
+	if imag(exp) != 0 || e != math.Trunc(e) || math.Abs(e) > math.MaxInt32 {
+		return cmplx.Pow(base, exp)
+	}
+	n := int64(e)
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	res := complex(1, 0)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			res *= base
+		}
+		base *= base
+	}
+	if negative {
+		return 1 / res
+	}
+	return res
+}
+
+// isBitwise checks if operator is a bitwise operator. Bitwise operators are only
+// defined for integers and are carried out by calcInt in all modes.
+func isBitwise(operator rune) bool {
+	switch operator {
+	case '&', '|', '⊕', '≪', '≫':
+		return true
+	}
+	return false
+}
+
+// maxShift is the largest shift count accepted by << and >>.
+const maxShift = 1 << 16
+
+// maxPowBits limits the number of bits of the numerator and denominator of exact
+// powers in types.ModeRational, like maxShift limits the results of <<.
+const maxPowBits = maxShift
+
+// calcInt carries out the bitwise operation indicated by operator on left and right
+// using big.Int. If one of the operands is not an integer an error wrapping
+// ErrNotInteger is returned.
+func calcInt(operator rune, left, right types.Value) (*big.Int, error) {
+	l, err := integer(operator, left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := integer(operator, right)
+	if err != nil {
+		return nil, err
+	}
+	z := new(big.Int)
+	switch operator {
+	case '&':
+		z.And(l, r)
+	case '|':
+		z.Or(l, r)
+	case '⊕':
+		z.Xor(l, r)
+	case '≪', '≫':
+		if r.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count %v", r)
+		}
+		if r.Cmp(big.NewInt(maxShift)) > 0 {
+			return nil, fmt.Errorf("shift count %v is larger than %d", r, maxShift)
+		}
+		if operator == '≪' {
+			z.Lsh(l, uint(r.Uint64()))
+		} else {
+			z.Rsh(l, uint(r.Uint64()))
+		}
+	default:
+		return nil, fmt.Errorf("unknown Operation: '%s'", operatorString(operator))
+	}
+	return z, nil
+}
+
+// integer converts v, an operand of operator, to a big.Int. If v is not an integer
+// an error wrapping ErrNotInteger is returned.
+func integer(operator rune, v types.Value) (*big.Int, error) {
+	i, ok := toInt(v)
+	if !ok {
+		return nil, fmt.Errorf("%v is %w, the operands of %s have to be integers", v, ErrNotInteger, operatorString(operator))
+	}
+	return i, nil
+}
+
+// fromInt converts z to the number type of the Mode of env.
+func fromInt(env *types.Env, z *big.Int) types.Value {
+	switch env.Mode() {
+	case types.ModeBigFloat:
+		return types.NewBigFloat(new(big.Float).SetPrec(env.Precision()).SetInt(z))
+	case types.ModeRational:
+		return types.NewRat(new(big.Rat).SetInt(z))
+	}
+	f, _ := new(big.Float).SetInt(z).Float64()
+	if env.Mode() == types.ModeComplex {
+		return types.Complex(complex(f, 0))
+	}
+	return types.Float(f)
+}
+
+// isComparison checks if operator compares its operands. The result of a comparison
+// is 1 if it is true and 0 otherwise.
+func isComparison(operator rune) bool {
+	switch operator {
+	case '≡', '≠', '<', '≤', '>', '≥':
+		return true
+	}
+	return false
+}
+
+// compare compares left and right using the number type selected by the Mode of
+// env. Complex numbers can only be ordered if both of them are real. Quantities
+// are compared by compareQuantities.
+func compare(env *types.Env, operator rune, left, right types.Value) (bool, error) {
+	for _, v := range []types.Value{left, right} {
+		if err := scalar(v); err != nil {
+			return false, err
+		}
+		if _, ok := v.(types.Quantity); ok {
+			return compareQuantities(env, operator, left, right)
+		}
+	}
+	var c int
+	switch env.Mode() {
+	case types.ModeBigFloat:
+		l, err := toBig(left, env.Precision())
+		if err != nil {
+			return false, err
+		}
+		r, err := toBig(right, env.Precision())
+		if err != nil {
+			return false, err
+		}
+		c = l.Cmp(r)
+	case types.ModeRational:
+		l, err := toRat(left)
+		if err != nil {
+			return false, err
+		}
+		r, err := toRat(right)
+		if err != nil {
+			return false, err
+		}
+		c = l.Cmp(r)
+	case types.ModeComplex:
+		l, r := toComplex(left), toComplex(right)
+		if imag(l) == 0 && imag(r) == 0 {
+			return compareFloat(operator, real(l), real(r)), nil
+		}
+		switch operator {
+		case '≡':
+			return l == r, nil
+		case '≠':
+			return l != r, nil
+		}
+		return false, fmt.Errorf("complex operands of %s are %w", operatorString(operator), types.ErrDomain)
+	default:
+		return compareFloat(operator, left.Float64(), right.Float64()), nil
+	}
+	switch operator {
+	case '≡':
+		return c == 0, nil
+	case '≠':
+		return c != 0, nil
+	case '<':
+		return c < 0, nil
+	case '≤':
+		return c <= 0, nil
+	case '>':
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+// compareFloat compares left and right. Like in Go, all comparisons with NaN are
+// false except for !=.
+func compareFloat(operator rune, left, right float64) bool {
+	switch operator {
+	case '≡':
+		return left == right
+	case '≠':
+		return left != right
+	case '<':
+		return left < right
+	case '≤':
+		return left <= right
+	case '>':
+		return left > right
+	default:
+		return left >= right
+	}
+}
+
+// truthy checks if v is true, which is the case for all values except for zero.
+// Quantities are true if their number is not zero. Lists are neither true nor
+// false, for them an error is returned.
+func truthy(v types.Value) (bool, error) {
+	switch n := v.(type) {
+	case types.Quantity:
+		return truthy(n.Value)
+	case types.BigFloat:
+		return n.Big().Sign() != 0, nil
+	case types.Rat:
+		return n.Big().Sign() != 0, nil
+	case types.Complex:
+		return n != 0, nil
+	case types.List:
+		return false, fmt.Errorf("the condition %v is a list, conditions have to be numbers", v)
+	}
+	return v.Float64() != 0, nil
+}
+
+// boolValue returns 1 if b is true and 0 otherwise, using the number type of the
+// Mode of env.
+func boolValue(env *types.Env, b bool) types.Value {
+	if b {
+		return fromInt(env, big.NewInt(1))
+	}
+	return fromInt(env, big.NewInt(0))
+}
+
+// basePrefixes contains the prefix of each base supported by FormatBase.
+var basePrefixes = map[int]string{
+	2:  "0b",
+	8:  "0o",
+	10: "",
+	16: "0x",
+}
+
+// FormatBase formats v as integer in the given base, which has to be 2, 8, 10 or 16.
+// Except for base 10, the result has the same prefix as the literals of that base,
+// e.g. 0x1f for 31 in base 16. If v is not an integer an error wrapping
+// ErrNotInteger is returned. The elements of lists are formatted individually,
+// e.g. [0x1, 0xa], quantities are formatted with their unit, e.g. 0xff m.
+func FormatBase(v types.Value, base int) (string, error) {
+	prefix, ok := basePrefixes[base]
+	if !ok {
+		return "", fmt.Errorf("unsupported base %d", base)
+	}
+	if l, ok := v.(types.List); ok {
+		elements := make([]string, len(l))
+		for i, e := range l {
+			s, err := FormatBase(e, base)
+			if err != nil {
+				return "", err
+			}
+			elements[i] = s
+		}
+		return "[" + strings.Join(elements, ", ") + "]", nil
+	}
+	if q, ok := v.(types.Quantity); ok {
+		s, err := FormatBase(q.Value, base)
+		if err != nil {
+			return "", err
+		}
+		return s + " " + q.Unit.String(), nil
+	}
+	n, ok := toInt(v)
+	if !ok {
+		return "", fmt.Errorf("%v is %w and cannot be formatted in base %d", v, ErrNotInteger, base)
+	}
+	if n.Sign() < 0 {
+		return "-" + prefix + new(big.Int).Neg(n).Text(base), nil
+	}
+	return prefix + n.Text(base), nil
+}
+
+// toInt converts v to a big.Int and reports whether v is an integer.
+func toInt(v types.Value) (*big.Int, bool) {
+	switch n := v.(type) {
+	case types.Rat:
+		if !n.Big().IsInt() {
+			return nil, false
+		}
+		return new(big.Int).Set(n.Big().Num()), true
+	case types.BigFloat:
+		if n.Big().IsInf() || !n.Big().IsInt() {
+			return nil, false
+		}
+		i, _ := n.Big().Int(nil)
+		return i, true
+	}
+	f := v.Float64()
+	if math.IsNaN(f) || math.IsInf(f, 0) || f != math.Trunc(f) {
+		return nil, false
+	}
+	i, _ := new(big.Float).SetFloat64(f).Int(nil)
+	return i, true
+}
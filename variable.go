@@ -0,0 +1,37 @@
+package calc
+
+import (
+	"github.com/maxmoehl/calc/types"
+)
+
+// variable is a reference to a variable that is looked up in the Env passed to
+// Eval. It is created for every identifier that is not followed by a brace.
+type variable struct {
+	name string
+	span
+}
+
+// Name implements types.Variable.
+func (v *variable) Name() string {
+	return v.name
+}
+
+func (v *variable) Eval(env *types.Env) (float64, error) {
+	return evalFloat(v, env)
+}
+
+// EvalValue returns the value of the variable. Values that have been assigned in
+// a different mode are converted to the Mode of env.
+func (v *variable) EvalValue(env *types.Env) (types.Value, error) {
+	val, ok := env.GetValue(v.name)
+	if !ok {
+		err := newError(KindUndefinedVariable, v.span, "undefined variable '%s'", v.name)
+		err.Token = v.name
+		return nil, err
+	}
+	val, err := convertValue(env, val)
+	if err != nil {
+		return nil, wrapError(KindEval, v.span, err)
+	}
+	return val, nil
+}